@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication adds credentials to outgoing requests. prepare acquires
+// them if necessary and is called before a request is timed, authorize only
+// adds them to the request. Implementations that hold a token can drop it
+// when the server answers with 401, so that the next request acquires a
+// fresh one.
+type Authentication interface {
+	prepare(ctx context.Context, c *Context) error
+	authorize(req *http.Request)
+	invalidate() bool
+}
+
+type BasicAuthentication struct {
+	Username string
+	Password string
+}
+
+func (a *BasicAuthentication) prepare(ctx context.Context, c *Context) error {
+	return nil
+}
+
+func (a *BasicAuthentication) authorize(req *http.Request) {
+	req.SetBasicAuth(a.Username, a.Password)
+}
+
+func (a *BasicAuthentication) invalidate() bool {
+	return false
+}
+
+// tokenRefreshMargin is the time before expiry at which a token is renewed.
+const tokenRefreshMargin = 30 * time.Second
+
+type bearerToken struct {
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+	// fetching is closed once the running fetch is done, it is nil if no
+	// fetch is running.
+	fetching chan struct{}
+	fetch    func(ctx context.Context, c *Context) (string, time.Time, error)
+}
+
+func (t *bearerToken) valid() bool {
+	return t.token != "" && (t.expires.IsZero() || time.Until(t.expires) >= tokenRefreshMargin)
+}
+
+// prepare fetches a new token if there is no valid one. Only one caller
+// fetches at a time, the others wait for its result without holding the
+// mutex.
+func (t *bearerToken) prepare(ctx context.Context, c *Context) error {
+	for {
+		t.mutex.Lock()
+		if t.valid() {
+			t.mutex.Unlock()
+			return nil
+		}
+		if fetching := t.fetching; fetching != nil {
+			t.mutex.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		fetching := make(chan struct{})
+		t.fetching = fetching
+		t.mutex.Unlock()
+
+		token, expires, err := t.fetch(ctx, c)
+		t.mutex.Lock()
+		if err == nil {
+			t.token, t.expires = token, expires
+		}
+		t.fetching = nil
+		t.mutex.Unlock()
+		close(fetching)
+		if err != nil {
+			return fmt.Errorf("failed to acquire jwt: %w", err)
+		}
+		return nil
+	}
+}
+
+func (t *bearerToken) authorize(req *http.Request) {
+	t.mutex.Lock()
+	token := t.token
+	t.mutex.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "bearer "+token)
+	}
+}
+
+func (t *bearerToken) invalidate() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.token = ""
+	return true
+}
+
+// beforeRequest waits for the rate limit and acquires the credentials for
+// the next request of a test thread. Test threads call it before they start
+// timing a request, so that neither is counted as latency.
+func (c *Context) beforeRequest(ctx context.Context) error {
+	if err := c.waitForRateLimit(ctx); err != nil {
+		return err
+	}
+	if c.Auth == nil {
+		return nil
+	}
+	return c.Auth.prepare(ctx, c)
+}
+
+const jwtAuthPath = "/_open/auth"
+
+// NewJWTAuthentication returns an Authentication that exchanges username and
+// password for a JWT using `_open/auth`.
+func NewJWTAuthentication(username, password string) Authentication {
+	return &bearerToken{fetch: func(ctx context.Context, c *Context) (string, time.Time, error) {
+		body, err := json.Marshal(struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}{username, password})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		// this bypasses Context.request, which would try to authorize the
+		// request and count it as part of the workload
+		url := c.endpoints.pick(c.worker).URL
+		fail := func(cause error) (string, time.Time, error) {
+			return "", time.Time{}, &ArangoError{Endpoint: url.Host, Method: "POST", Path: jwtAuthPath, Cause: cause}
+		}
+		url.Path = jwtAuthPath
+		req, err := http.NewRequestWithContext(ctx, "POST", url.String(), bytes.NewReader(body))
+		if err != nil {
+			return fail(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return fail(err)
+		}
+		defer resp.Body.Close()
+
+		var target struct {
+			Jwt string `json:"jwt"`
+		}
+		if resp.StatusCode != 200 {
+			return fail(fmt.Errorf("unexpected status code: %d", resp.StatusCode))
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&target); err != nil {
+			return fail(fmt.Errorf("error while reading the response: %w", err))
+		}
+		return target.Jwt, jwtExpiry(target.Jwt), nil
+	}}
+}
+
+// superuserTokenLifetime is the validity of self signed superuser tokens.
+const superuserTokenLifetime = time.Hour
+
+// NewSuperuserAuthentication returns an Authentication that signs superuser
+// tokens with the JWT secret stored in secretFile.
+func NewSuperuserAuthentication(secretFile string) (Authentication, error) {
+	secret, err := ioutil.ReadFile(secretFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwt secret: %w", err)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("jwt secret file %s is empty", secretFile)
+	}
+
+	return &bearerToken{fetch: func(context.Context, *Context) (string, time.Time, error) {
+		now := time.Now()
+		expires := now.Add(superuserTokenLifetime)
+		token, err := signJWT(secret, map[string]interface{}{
+			"iss":       "arangodb",
+			"server_id": "replicated-logs-perf-test",
+			"iat":       now.Unix(),
+			"exp":       expires.Unix(),
+		})
+		return token, expires, err
+	}}, nil
+}
+
+func signJWT(secret []byte, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+// jwtExpiry returns the `exp` claim of a token, or the zero time if the token
+// does not carry one.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(claims.Exp), 0)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWTFetchIsNotTimed(t *testing.T) {
+	const token = "header.payload.signature"
+	var fetches, inserts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.URL.Path == jwtAuthPath:
+			atomic.AddInt32(&fetches, 1)
+			time.Sleep(300 * time.Millisecond)
+			fmt.Fprintf(w, `{"jwt":%q}`, token)
+		case req.Header.Get("Authorization") != "bearer "+token:
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"result":{"index":%d}}`, atomic.AddInt32(&inserts, 1))
+		}
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{Auth: NewJWTAuthentication("root", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := TestSettings{NumberOfRequests: 2, NumberOfThreads: 4, Config: Config{WriteConcern: 1}}
+	results, err := runThreads(context.Background(), c, &ReplicatedLogsTest{}, 1, test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range results {
+		if d >= 300*time.Millisecond {
+			t.Errorf("request %d took %v, which includes the token fetch", i, d)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("%d token fetches, expected 1", n)
+	}
+}
+
+func TestJWTExpiredOnServer(t *testing.T) {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.URL.Path == jwtAuthPath:
+			fmt.Fprintf(w, `{"jwt":"token-%d"}`, atomic.AddInt32(&fetches, 1))
+		case req.Header.Get("Authorization") != "bearer token-2":
+			// the first token has expired on the server
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{Auth: NewJWTAuthentication("root", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.dropReplicatedLog(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("%d token fetches, expected 2", n)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Cassette records the requests of a Context or replays recorded ones.
+type Cassette interface {
+	wrap(transport http.RoundTripper) http.RoundTripper
+	Close() error
+}
+
+// cassetteEntry is a single request with its response, stored as one line
+// of the cassette file.
+type cassetteEntry struct {
+	Method         string        `json:"method"`
+	Path           string        `json:"path"`
+	RequestHeader  http.Header   `json:"requestHeader,omitempty"`
+	RequestBody    *cassetteBody `json:"requestBody,omitempty"`
+	Status         int           `json:"status"`
+	ResponseHeader http.Header   `json:"responseHeader,omitempty"`
+	ResponseBody   *cassetteBody `json:"responseBody,omitempty"`
+	Start          time.Time     `json:"start"`
+	Duration       float64       `json:"duration"`
+
+	replayed bool
+}
+
+// cassetteBody keeps text bodies readable, binary ones such as velocypack
+// are base64 encoded.
+type cassetteBody struct {
+	Data   string `json:"data"`
+	Base64 bool   `json:"base64,omitempty"`
+}
+
+func newCassetteBody(data []byte) *cassetteBody {
+	if len(data) == 0 {
+		return nil
+	}
+	if utf8.Valid(data) {
+		return &cassetteBody{Data: string(data)}
+	}
+	return &cassetteBody{Data: base64.StdEncoding.EncodeToString(data), Base64: true}
+}
+
+func (b *cassetteBody) bytes() ([]byte, error) {
+	if b == nil {
+		return nil, nil
+	}
+	if b.Base64 {
+		return base64.StdEncoding.DecodeString(b.Data)
+	}
+	return []byte(b.Data), nil
+}
+
+func cassetteKey(method, path string) string {
+	return method + " " + path
+}
+
+// RecordingCassette writes every request and its response to a file.
+type RecordingCassette struct {
+	mutex sync.Mutex
+	file  *os.File
+	out   *json.Encoder
+}
+
+func NewRecordingCassette(path string) (*RecordingCassette, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cassette: %w", err)
+	}
+	return &RecordingCassette{file: file, out: json.NewEncoder(file)}, nil
+}
+
+func (r *RecordingCassette) Close() error {
+	return r.file.Close()
+}
+
+func (r *RecordingCassette) wrap(transport http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{cassette: r, next: transport}
+}
+
+func (r *RecordingCassette) write(entry *cassetteEntry) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.out.Encode(entry)
+}
+
+type recordingTransport struct {
+	cassette *RecordingCassette
+	next     http.RoundTripper
+}
+
+func (t *recordingTransport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	entry := &cassetteEntry{
+		Method:        req.Method,
+		Path:          req.URL.RequestURI(),
+		RequestHeader: req.Header.Clone(),
+		RequestBody:   newCassetteBody(body),
+		Start:         time.Now(),
+	}
+	// credentials must not end up in the cassette
+	entry.RequestHeader.Del("Authorization")
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	entry.Status = resp.StatusCode
+	entry.ResponseHeader = resp.Header
+	entry.ResponseBody = newCassetteBody(data)
+	entry.Duration = time.Since(entry.Start).Seconds()
+	if req.URL.Path == jwtAuthPath {
+		redactJWTAuth(entry)
+	}
+	if err := t.cassette.write(entry); err != nil {
+		return nil, fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return resp, nil
+}
+
+// redactJWTAuth removes the credentials from a recorded JWT request and the
+// token from its response. Replaying it yields a token that is never sent
+// anywhere.
+func redactJWTAuth(entry *cassetteEntry) {
+	entry.RequestBody = nil
+	if entry.Status == http.StatusOK {
+		entry.ResponseBody = newCassetteBody([]byte(`{"jwt":"redacted"}`))
+	}
+}
+
+// ReplayCassette answers requests with the responses of a recorded
+// cassette instead of sending them. Requests are matched by method, path and
+// body, or by method and path only if no recorded request has the same body.
+// Matching requests get the recorded responses in their recorded order.
+type ReplayCassette struct {
+	mutex   sync.Mutex
+	entries map[string][]*cassetteEntry
+	// bodies holds the same entries as entries, keyed by the request body
+	// in addition.
+	bodies map[string][]*cassetteEntry
+}
+
+func NewReplayCassette(path string) (*ReplayCassette, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %w", err)
+	}
+	defer file.Close()
+
+	r := &ReplayCassette{entries: make(map[string][]*cassetteEntry), bodies: make(map[string][]*cassetteEntry)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		entry := &cassetteEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("invalid cassette entry in line %d: %w", line, err)
+		}
+		body, err := entry.RequestBody.bytes()
+		if err != nil {
+			return nil, fmt.Errorf("invalid cassette entry in line %d: %w", line, err)
+		}
+		key := cassetteKey(entry.Method, entry.Path)
+		r.entries[key] = append(r.entries[key], entry)
+		r.bodies[key+" "+string(body)] = append(r.bodies[key+" "+string(body)], entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	return r, nil
+}
+
+func (r *ReplayCassette) Close() error {
+	return nil
+}
+
+func (r *ReplayCassette) wrap(http.RoundTripper) http.RoundTripper {
+	return r
+}
+
+func (r *ReplayCassette) next(req *http.Request, body []byte) (*cassetteEntry, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := cassetteKey(req.Method, req.URL.RequestURI())
+	entry := popCassetteEntry(r.bodies, key+" "+string(body))
+	if entry == nil {
+		entry = popCassetteEntry(r.entries, key)
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("no recorded response left for %s", key)
+	}
+	entry.replayed = true
+	return entry, nil
+}
+
+// popCassetteEntry removes and returns the first entry for key that was not
+// yet replayed, or nil if there is none.
+func popCassetteEntry(entries map[string][]*cassetteEntry, key string) *cassetteEntry {
+	list := entries[key]
+	for len(list) > 0 && list[0].replayed {
+		list = list[1:]
+	}
+	if len(list) == 0 {
+		delete(entries, key)
+		return nil
+	}
+	entries[key] = list[1:]
+	return list[0]
+}
+
+func (r *ReplayCassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		requestBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	entry, err := r.next(req, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	body, err := entry.ResponseBody.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded body for %s %s: %w", entry.Method, entry.Path, err)
+	}
+	header := entry.ResponseHeader.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Status, http.StatusText(entry.Status)),
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cassetteRecorder builds a cassette for replay tests.
+type cassetteRecorder struct {
+	t       *testing.T
+	entries []cassetteEntry
+}
+
+// add records a request with a json body and its response. Bodies that are
+// strings are stored as they are.
+func (r *cassetteRecorder) add(method, path string, requestBody interface{}, status int, responseBody interface{}) {
+	r.entries = append(r.entries, cassetteEntry{
+		Method:         method,
+		Path:           path,
+		RequestBody:    newCassetteBody(r.marshal(requestBody)),
+		Status:         status,
+		ResponseHeader: http.Header{"Content-Type": {"application/json"}},
+		ResponseBody:   newCassetteBody(r.marshal(responseBody)),
+	})
+}
+
+func (r *cassetteRecorder) marshal(v interface{}) []byte {
+	if v == nil {
+		return nil
+	}
+	if s, ok := v.(string); ok {
+		return []byte(s)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return data
+}
+
+// replay writes the cassette and returns a context that replays it.
+func (r *cassetteRecorder) replay(opts ContextOptions) (*Context, *ReplayCassette) {
+	path := filepath.Join(r.t.TempDir(), "cassette.jsonl")
+	file, err := os.Create(path)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	out := json.NewEncoder(file)
+	for i := range r.entries {
+		if err := out.Encode(&r.entries[i]); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	file.Close()
+
+	cassette, err := NewReplayCassette(path)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	opts.Cassette = cassette
+	c, err := NewContext([]url.URL{{Scheme: "http", Host: "replay.invalid:8529"}}, opts)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return c, cassette
+}
+
+// unplayed returns the requests of the cassette that were not replayed.
+func (r *ReplayCassette) unplayed() []string {
+	var result []string
+	for key, entries := range r.entries {
+		for _, entry := range entries {
+			if !entry.replayed {
+				result = append(result, key)
+			}
+		}
+	}
+	return result
+}
+
+func TestReplayReplicatedLogsTest(t *testing.T) {
+	const id = 7
+	test := TestSettings{NumberOfRequests: 5, NumberOfThreads: 3, NumberOfServers: 3, Config: Config{WriteConcern: 2, SoftWriteConcern: 2}}
+
+	r := &cassetteRecorder{t: t}
+	r.add("POST", "/_api/log", struct {
+		Id     uint   `json:"id"`
+		Config Config `json:"config"`
+	}{id, test.Config.logConfig()}, 200, map[string]interface{}{})
+	// the log is only used once a leader is elected
+	r.add("GET", "/_api/log/7", nil, 200, logStatusResponse(""))
+	r.add("GET", "/_api/log/7", nil, 200, logStatusResponse("PRMR-1"))
+	// the entries of all threads are interleaved in the log
+	for k := 0; k < test.NumberOfRequests; k++ {
+		for thread := 0; thread < test.NumberOfThreads; thread++ {
+			index := uint64(k*test.NumberOfThreads + thread + 1)
+			quorum := []string{"PRMR-1", "PRMR-2"}
+			if thread == 0 {
+				quorum = []string{"PRMR-3", "PRMR-1"}
+			}
+			r.add("POST", "/_api/log/7/insert", LogEntry{thread, k}, 201, insertResponse(index, quorum...))
+		}
+	}
+	r.add("DELETE", "/_api/log/7", nil, 200, map[string]interface{}{})
+
+	c, cassette := r.replay(ContextOptions{})
+	impl := &ReplicatedLogsTest{}
+	ctx := context.Background()
+	if err := impl.SetupTest(ctx, c, id, test); err != nil {
+		t.Fatal(err)
+	}
+	results, err := runThreads(ctx, c, impl, id, test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := impl.TearDownTest(ctx, c, id); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, d := range results {
+		if d <= 0 {
+			t.Errorf("request %d has no latency", i)
+		}
+	}
+	quorum, err := impl.QuorumResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quorum.Committed != 15 || quorum.Compositions["PRMR-1,PRMR-2"] != 10 || quorum.Compositions["PRMR-1,PRMR-3"] != 5 ||
+		quorum.Participants["PRMR-1"] != 1 || quorum.Size != 2 {
+		t.Errorf("unexpected quorum results %+v", quorum)
+	}
+	if unplayed := cassette.unplayed(); len(unplayed) > 0 {
+		t.Errorf("requests were not sent: %v", unplayed)
+	}
+}
+
+func TestReplayRetry(t *testing.T) {
+	test := TestSettings{NumberOfRequests: 1, NumberOfThreads: 1, Config: Config{WriteConcern: 1}}
+	r := &cassetteRecorder{t: t}
+	r.add("POST", "/_api/log/3/insert", LogEntry{0, 0}, 503, map[string]interface{}{
+		"error": true, "code": 503, "errorNum": ErrorClusterNotLeader, "errorMessage": "not leader",
+	})
+	r.add("POST", "/_api/log/3/insert", LogEntry{0, 0}, 201, insertResponse(1))
+
+	c, cassette := r.replay(ContextOptions{Retry: RetryPolicy{MaxAttempts: 3, RetryOn: []ErrorClass{RetryLeaderChanged}}})
+	if _, err := runThreads(context.Background(), c, &ReplicatedLogsTest{}, 3, test); err != nil {
+		t.Fatal(err)
+	}
+	if c.stats.retries != 1 {
+		t.Errorf("%d retries, expected 1", c.stats.retries)
+	}
+	if unplayed := cassette.unplayed(); len(unplayed) > 0 {
+		t.Errorf("requests were not sent: %v", unplayed)
+	}
+}
+
+func TestReplayDocumentTests(t *testing.T) {
+	test := TestSettings{
+		NumberOfRequests: 4,
+		NumberOfThreads:  2,
+		NumberOfServers:  3,
+		Config:           Config{WriteConcern: 2, NumberOfShards: 3, BatchSize: 2, ReplicationVersion: "2"},
+	}
+	impl := &DocumentTests{}
+	dbname := impl.GetTestName(test)
+
+	r := &cassetteRecorder{t: t}
+	r.add("POST", "/_api/database", map[string]interface{}{"name": dbname, "options": map[string]string{"replicationVersion": "2"}},
+		201, map[string]interface{}{"result": true})
+	r.add("POST", fmt.Sprintf("/_db/%s/_api/collection", dbname), CreateCollectionOptions{
+		Name: CollectionName, WriteConcern: 2, ReplicationFactor: 3, NumberOfShards: 3,
+	}, 200, map[string]interface{}{"name": CollectionName})
+	// the documents are random, so their inserts are matched by path only
+	for i := 0; i < test.NumberOfRequests*test.NumberOfThreads; i++ {
+		r.add("POST", fmt.Sprintf("/_db/%s/_api/document/c", dbname), "recorded", 202, []interface{}{})
+	}
+	r.add("DELETE", fmt.Sprintf("/_api/database/%s", dbname), nil, 200, map[string]interface{}{"result": true})
+
+	c, cassette := r.replay(ContextOptions{})
+	ctx := context.Background()
+	if err := impl.SetupTest(ctx, c, 0, test); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runThreads(ctx, c, impl, 0, test); err != nil {
+		t.Fatal(err)
+	}
+	if err := impl.TearDownTest(ctx, c, 0); err != nil {
+		t.Fatal(err)
+	}
+	if unplayed := cassette.unplayed(); len(unplayed) > 0 {
+		t.Errorf("requests were not sent: %v", unplayed)
+	}
+}
+
+func TestReplayDocumentTestsSetupFailure(t *testing.T) {
+	test := TestSettings{NumberOfThreads: 1, Config: Config{WriteConcern: 1, ReplicationVersion: "2"}}
+	r := &cassetteRecorder{t: t}
+	r.add("POST", "/_api/database", nil, 409, map[string]interface{}{
+		"error": true, "code": 409, "errorNum": ErrorDuplicateName, "errorMessage": "duplicate database name",
+	})
+
+	c, _ := r.replay(ContextOptions{})
+	err := (&DocumentTests{}).SetupTest(context.Background(), c, 0, test)
+	if err == nil || !strings.Contains(err.Error(), "duplicate database name") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestReplayMissingResponse(t *testing.T) {
+	c, _ := (&cassetteRecorder{t: t}).replay(ContextOptions{})
+	err := c.createReplicatedLog(context.Background(), 1, Config{})
+	if _, ok := asArangoError(err); !ok || !strings.Contains(err.Error(), "no recorded response left") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestRecordJWTAuthentication(t *testing.T) {
+	const password, token = "very-secret", "header.payload.signature"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.URL.Path == jwtAuthPath:
+			fmt.Fprintf(w, `{"jwt":%q}`, token)
+		case req.Header.Get("Authorization") != "bearer "+token:
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+	recorder, err := NewRecordingCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{Auth: NewJWTAuthentication("root", password), Cassette: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.createReplicatedLog(context.Background(), 1, Config{}); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), password) || strings.Contains(string(data), token) {
+		t.Errorf("cassette contains credentials: %s", data)
+	}
+
+	// the redacted cassette still replays without a server
+	server.Close()
+	cassette, err := NewReplayCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewContext([]url.URL{*endpoint}, ContextOptions{Auth: NewJWTAuthentication("root", "other"), Cassette: cassette})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.createReplicatedLog(context.Background(), 1, Config{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayUnmeasuredRequests(t *testing.T) {
+	r := &cassetteRecorder{t: t}
+	r.add("DELETE", "/_api/log/3", nil, 200, map[string]interface{}{})
+	r.add("DELETE", "/_api/log/4", nil, 200, map[string]interface{}{})
+
+	c, _ := r.replay(ContextOptions{})
+	cc := c.unmeasured()
+	if err := cc.dropReplicatedLog(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	if c.stats.bytesReceived != 0 || c.endpoints.results()["replay.invalid:8529"].Requests != 0 {
+		t.Errorf("unmeasured request was counted: %d bytes, %+v", c.stats.bytesReceived, c.endpoints.results())
+	}
+	if err := c.dropReplicatedLog(context.Background(), 4); err != nil {
+		t.Fatal(err)
+	}
+	if c.stats.bytesReceived != 2 || c.endpoints.results()["replay.invalid:8529"].Requests != 1 {
+		t.Errorf("request was not counted: %d bytes, %+v", c.stats.bytesReceived, c.endpoints.results())
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+)
+
+type ConnectionMode string
+
+const (
+	// SharedConnections uses one keep-alive pool for all workers.
+	SharedConnections ConnectionMode = "shared"
+	// WorkerConnections gives every worker a dedicated connection.
+	WorkerConnections ConnectionMode = "worker"
+	// PooledConnections shares a fixed number of connections per coordinator
+	// between all workers.
+	PooledConnections ConnectionMode = "pool"
+	// NoKeepAlive opens a new connection for every request.
+	NoKeepAlive ConnectionMode = "no-keep-alive"
+)
+
+func parseConnectionMode(s string) (ConnectionMode, error) {
+	switch m := ConnectionMode(s); m {
+	case SharedConnections, WorkerConnections, PooledConnections, NoKeepAlive:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown connection mode %q", s)
+}
+
+// connectionModeSuffix returns the part of the test name describing the
+// connection mode, which is empty for the default shared pool.
+func connectionModeSuffix(test TestSettings) string {
+	switch test.ConnectionMode {
+	case WorkerConnections:
+		return "-conn-worker"
+	case PooledConnections:
+		return fmt.Sprintf("-conn-pool%d", test.ConnectionPoolSize)
+	case NoKeepAlive:
+		return "-conn-nokeepalive"
+	}
+	return ""
+}
+
+// withConnectionMode returns a copy of the context that manages its
+// connections as configured for the test.
+func (c *Context) withConnectionMode(test TestSettings) (*Context, error) {
+	result := *c
+	switch test.ConnectionMode {
+	case "", SharedConnections:
+		return c, nil
+	case WorkerConnections:
+		result.clientPerWorker = true
+	case PooledConnections:
+		if test.ConnectionPoolSize <= 0 {
+			return nil, fmt.Errorf("connection pool size has to be positive, got %d", test.ConnectionPoolSize)
+		}
+		result.Client = c.newClient(connectionOptions{maxConnsPerHost: test.ConnectionPoolSize})
+	case NoKeepAlive:
+		result.Client = c.newClient(connectionOptions{disableKeepAlives: true})
+	default:
+		return nil, fmt.Errorf("unknown connection mode %q", test.ConnectionMode)
+	}
+	return &result, nil
+}
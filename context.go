@@ -2,18 +2,120 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Context struct {
-	Endpoint url.URL
-	Client   *http.Client
+	Client *http.Client
+	Auth   Authentication
+
+	tlsConfig      *tls.Config
+	protocol       Protocol
+	encoding       Encoding
+	retry          RetryPolicy
+	readiness      []ReadinessCondition
+	cassette       Cassette
+	requestTimeout time.Duration
+	endpoints      *endpointPool
+	stats          *contextStats
+	// worker is the number of the test thread this context belongs to, or
+	// -1 if it is not bound to a thread.
+	worker int
+	// clientPerWorker is set if every worker uses its own connection.
+	clientPerWorker bool
+	// limiter is shared by all workers, workerLimiter belongs to a single
+	// worker and is created with workerRate.
+	limiter       *rateLimiter
+	workerLimiter *rateLimiter
+	workerRate    float64
+}
+
+func (c *Context) forWorker(worker int) *Context {
+	result := *c
+	result.worker = worker
+	if c.clientPerWorker {
+		result.Client = c.newClient(connectionOptions{maxConnsPerHost: 1})
+	}
+	if c.workerRate > 0 {
+		result.workerLimiter = newRateLimiter(c.workerRate)
+	}
+	return &result
+}
+
+// withRequestTimeout returns a copy of the context that cancels each single
+// request after timeout. Zero keeps the current timeout.
+func (c *Context) withRequestTimeout(timeout time.Duration) *Context {
+	result := *c
+	if timeout > 0 {
+		result.requestTimeout = timeout
+	}
+	return &result
+}
+
+// unmeasured returns a copy of the context for requests that run beside the
+// workload of a test, such as consumers following a log. It has its own
+// stats, connections and endpoint counters, so its requests do not show up
+// in the results, and it is not rate limited. The caller has to close the
+// idle connections of its client.
+func (c *Context) unmeasured() *Context {
+	result := *c
+	result.worker = -1
+	result.stats = &contextStats{}
+	result.endpoints = c.endpoints.clone()
+	result.limiter, result.workerLimiter = nil, nil
+	result.Client = result.newClient(connectionOptions{})
+	return &result
+}
+
+// contextStats collects transport level counters of all requests sent
+// through a Context. All fields are updated atomically.
+type contextStats struct {
+	tlsHandshakes    int64
+	tlsHandshakeTime int64
+	retries          int64
+	retryTime        int64
+	connections      int64
+	rateLimited      int64
+	bytesReceived    int64
+	rateLimitWait    int64
+
+	phases phaseRecorder
+	server serverTimingRecorder
+}
+
+func (s *contextStats) reset() {
+	atomic.StoreInt64(&s.tlsHandshakes, 0)
+	atomic.StoreInt64(&s.tlsHandshakeTime, 0)
+	atomic.StoreInt64(&s.retries, 0)
+	atomic.StoreInt64(&s.retryTime, 0)
+	atomic.StoreInt64(&s.connections, 0)
+	atomic.StoreInt64(&s.rateLimited, 0)
+	atomic.StoreInt64(&s.bytesReceived, 0)
+	atomic.StoreInt64(&s.rateLimitWait, 0)
+	s.phases.reset()
+	s.server.reset()
+}
+
+func (s *contextStats) record(t *requestTrace, done time.Time) {
+	s.phases.record(t, done)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.tlsStart.IsZero() && !t.tlsDone.IsZero() {
+		atomic.AddInt64(&s.tlsHandshakes, 1)
+		atomic.AddInt64(&s.tlsHandshakeTime, int64(t.tlsDone.Sub(t.tlsStart)))
+	}
 }
 
 type DatabaseContext struct {
@@ -21,7 +123,7 @@ type DatabaseContext struct {
 	Database string
 }
 
-func (c *Context) createReplicatedLog(id uint, config Config) error {
+func (c *Context) createReplicatedLog(ctx context.Context, id uint, config Config) error {
 	type Definition struct {
 		Id           uint   `json:"id"`
 		TargetConfig Config `json:"config"`
@@ -32,176 +134,171 @@ func (c *Context) createReplicatedLog(id uint, config Config) error {
 		TargetConfig: config,
 	}
 
-	body, err := json.Marshal(def)
-	if err != nil {
-		return fmt.Errorf("error while creating log: %w", err)
-	}
+	return c.request(ctx, "POST", "_api/log", def, nil, 200)
+}
 
-	url := c.Endpoint
-	url.Path = "_api/log"
-	resp, err := c.Client.Post(url.String(), "application/json", bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("error while creating log: %w", err)
-	}
-	var target struct {
-		Code         int    `json:"code,omitempty"`
-		Error        bool   `json:"error,omitempty"`
-		ErrorMessage string `json:"errorMessage,omitempty"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&target); err != nil {
-		return fmt.Errorf("error while reading the response: %v", err)
-	}
-	defer resp.Body.Close()
+func (c *Context) dropReplicatedLog(ctx context.Context, id uint) error {
+	return c.request(ctx, "DELETE", fmt.Sprintf("_api/log/%d", id), nil, nil, 200, 202)
+}
 
-	if resp.StatusCode != 200 || target.Error {
-		return fmt.Errorf("error while creating log: status-code=%d, error-code=%d, message=%s", resp.StatusCode, target.Code, target.ErrorMessage)
-	}
+// ReplicatedLogInsertResult is the response to an insert. For multiple
+// entries Index is the one of the last entry.
+type ReplicatedLogInsertResult struct {
+	Index       uint64
+	CommitIndex uint64
+	// Term and Quorum describe the quorum that committed the entry, they
+	// are empty if the entry was not yet committed.
+	Term   uint64
+	Quorum []string
+}
 
-	return nil
+type replicatedLogInsertResponse struct {
+	Result struct {
+		Index   uint64   `json:"index"`
+		Indexes []uint64 `json:"indexes"`
+		Result  struct {
+			CommitIndex uint64 `json:"commitIndex"`
+			Quorum      *struct {
+				Term   uint64   `json:"term"`
+				Quorum []string `json:"quorum"`
+			} `json:"quorum"`
+		} `json:"result"`
+	} `json:"result"`
 }
 
-func (c *Context) dropReplicatedLog(id uint) error {
-	url := c.Endpoint
-	url.Path = fmt.Sprintf("_api/log/%d", id)
-	req, err := http.NewRequest("DELETE", url.String(), nil)
-	if err != nil {
-		return fmt.Errorf("error while dropping log: %w", err)
+func (r *replicatedLogInsertResponse) insertResult() *ReplicatedLogInsertResult {
+	result := &ReplicatedLogInsertResult{
+		Index:       r.Result.Index,
+		CommitIndex: r.Result.Result.CommitIndex,
 	}
-
-	resp, err := c.Client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error while dropping log: %w", err)
+	for _, index := range r.Result.Indexes {
+		if index > result.Index {
+			result.Index = index
+		}
 	}
-	if _, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
-		return fmt.Errorf("failed to read body: %w", err)
+	if q := r.Result.Result.Quorum; q != nil {
+		result.Term, result.Quorum = q.Term, q.Quorum
 	}
-	defer resp.Body.Close()
+	return result
+}
 
-	if resp.StatusCode != 200 && resp.StatusCode != 202 {
-		return fmt.Errorf("error while dropping log: Unexpected status code: %d", resp.StatusCode)
+func (c *Context) insertReplicatedLog(ctx context.Context, id uint, payload interface{}) (*ReplicatedLogInsertResult, error) {
+	var response replicatedLogInsertResponse
+	if err := c.request(ctx, "POST", fmt.Sprintf("_api/log/%d/insert", id), payload, &response, 201, 202); err != nil {
+		return nil, err
 	}
-	return nil
+	return response.insertResult(), nil
 }
 
-func (c *Context) waitForReplicatedLog(id uint) error {
-	for {
-		url := c.Endpoint
-		url.Path = fmt.Sprintf("_api/log/%d", id)
-		resp, err := c.Client.Get(url.String())
-		if err != nil {
-			return fmt.Errorf("error while requesting log status: %w", err)
-		}
+// multiInsertReplicatedLog inserts every element of payloads, which has to
+// be a slice, as a separate entry.
+func (c *Context) multiInsertReplicatedLog(ctx context.Context, id uint, payloads interface{}) (*ReplicatedLogInsertResult, error) {
+	var response replicatedLogInsertResponse
+	if err := c.request(ctx, "POST", fmt.Sprintf("_api/log/%d/multi-insert", id), payloads, &response, 201, 202); err != nil {
+		return nil, err
+	}
+	return response.insertResult(), nil
+}
 
-		var target struct {
-			Code   int  `json:"code,omitempty"`
-			Error  bool `json:"error,omitempty"`
-			Result struct {
-				LeaderId string `json:"leaderId,omitempty"`
-			} `json:"result,omitempty"`
-		}
+// setReplicatedLogLeader asks the log to make server its leader, which
+// starts a new term.
+func (c *Context) setReplicatedLogLeader(ctx context.Context, id uint, server string) error {
+	return c.request(ctx, "POST", fmt.Sprintf("_api/log/%d/leader/%s", id, url.PathEscape(server)), nil, nil, 200, 202)
+}
+
+// replaceReplicatedLogParticipant replaces the participant old with the
+// server replacement.
+func (c *Context) replaceReplicatedLogParticipant(ctx context.Context, id uint, old, replacement string) error {
+	return c.request(ctx, "POST", fmt.Sprintf("_api/log/%d/participant/%s/replace-with/%s", id, url.PathEscape(old), url.PathEscape(replacement)), nil, nil, 200, 202)
+}
+
+// updateReplicatedLogConfig changes the target configuration of the log.
+func (c *Context) updateReplicatedLogConfig(ctx context.Context, id uint, config Config) error {
+	return c.request(ctx, "POST", fmt.Sprintf("_api/log/%d/config", id), config, nil, 200, 202)
+}
 
-		json.NewDecoder(resp.Body).Decode(&target)
-		defer resp.Body.Close()
-		if resp.StatusCode == 200 && len(target.Result.LeaderId) > 0 {
-			return nil
+// getHealthyDBServers returns the ids of all database servers the cluster
+// considers healthy.
+func (c *Context) getHealthyDBServers(ctx context.Context) ([]string, error) {
+	var target struct {
+		Health map[string]struct {
+			Role   string `json:"Role"`
+			Status string `json:"Status"`
+		} `json:"Health"`
+	}
+	if err := c.request(ctx, "GET", "_admin/cluster/health", nil, &target, 200); err != nil {
+		return nil, err
+	}
+	var servers []string
+	for id, server := range target.Health {
+		if server.Role == "DBServer" && server.Status == "GOOD" {
+			servers = append(servers, id)
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
+	sort.Strings(servers)
+	return servers, nil
 }
 
-func (c *Context) printStatus(id int) error {
-	url := c.Endpoint
-	url.Path = fmt.Sprintf("_api/log/%d", id)
-	resp, err := c.Client.Get(url.String())
-	if err != nil {
-		return err
-	}
-	if _, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
-		return fmt.Errorf("failed to read body: %w", err)
-	}
-	defer resp.Body.Close()
+// releaseReplicatedLog allows the log to compact all entries up to index.
+func (c *Context) releaseReplicatedLog(ctx context.Context, id uint, index uint64) error {
+	return c.request(ctx, "POST", fmt.Sprintf("_api/log/%d/release?index=%d", id, index), nil, nil, 200, 202)
+}
 
-	fmt.Printf("Status: %s\n", resp.Body)
-	return nil
+// The read functions decode their response into result, which may be nil
+// to discard it.
+
+func (c *Context) headReplicatedLog(ctx context.Context, id uint, limit uint, result interface{}) error {
+	return c.request(ctx, "GET", fmt.Sprintf("_api/log/%d/head?limit=%d", id, limit), nil, result, 200)
 }
 
-func (c *Context) insertReplicatedLog(id uint, payload interface{}) error {
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("error while inserting entry: %w", err)
-	}
-	url := c.Endpoint
-	url.Path = fmt.Sprintf("_api/log/%d/insert", id)
-	resp, err := c.Client.Post(url.String(), "application/json", bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("error while inserting entry: %w", err)
+func (c *Context) tailReplicatedLog(ctx context.Context, id uint, limit uint, result interface{}) error {
+	return c.request(ctx, "GET", fmt.Sprintf("_api/log/%d/tail?limit=%d", id, limit), nil, result, 200)
+}
+
+// sliceReplicatedLog reads the entries from start up to, but excluding, stop.
+func (c *Context) sliceReplicatedLog(ctx context.Context, id uint, start, stop uint64, result interface{}) error {
+	return c.request(ctx, "GET", fmt.Sprintf("_api/log/%d/slice?start=%d&stop=%d", id, start, stop), nil, result, 200)
+}
+
+// LogEntryView is an entry as returned by the read functions.
+type LogEntryView struct {
+	LogIndex uint64          `json:"logIndex"`
+	LogTerm  uint64          `json:"logTerm"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+}
+
+// pollReplicatedLog waits until entries starting at first are available and
+// returns up to limit of them.
+func (c *Context) pollReplicatedLog(ctx context.Context, id uint, first uint64, limit uint) ([]LogEntryView, error) {
+	var target struct {
+		Result []LogEntryView `json:"result"`
 	}
-	if _, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
-		return fmt.Errorf("failed to read body: %w", err)
+	if err := c.request(ctx, "GET", fmt.Sprintf("_api/log/%d/poll?first=%d&limit=%d", id, first, limit), nil, &target, 200); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return target.Result, nil
+}
 
-	if resp.StatusCode != 201 && resp.StatusCode != 202 {
-		return fmt.Errorf("error while inserting entry: Unexpected status code: %d", resp.StatusCode)
-	}
-	return nil
+func (c *Context) readReplicatedLogEntry(ctx context.Context, id uint, index uint64, result interface{}) error {
+	return c.request(ctx, "GET", fmt.Sprintf("_api/log/%d/entry/%d", id, index), nil, result, 200)
 }
 
 type DatabaseOptions struct {
 	ReplicationVersion *string `json:"replicationVersion,omitempty"`
 }
 
-func (c *Context) createDatabase(name string, opts *DatabaseOptions) error {
+func (c *Context) createDatabase(ctx context.Context, name string, opts *DatabaseOptions) error {
 
 	type CreateDatabaseBody struct {
 		Name    string           `json:"name"`
 		Options *DatabaseOptions `json:"options,omitempty"`
 	}
 
-	req := CreateDatabaseBody{name, opts}
-	body, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("error while creating database: %w", err)
-	}
-	url := c.Endpoint
-	url.Path = "_api/database"
-	resp, err := c.Client.Post(url.String(), "application/json", bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("error while creating database: %w", err)
-	}
-	if _, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
-		return fmt.Errorf("failed to read body: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 201 {
-		return fmt.Errorf("error while creating database: %d", resp.StatusCode)
-	}
-	return nil
+	return c.request(ctx, "POST", "_api/database", CreateDatabaseBody{name, opts}, nil, 201)
 }
 
-func (c *Context) dropDatabase(name string) error {
-
-	url := c.Endpoint
-	url.Path = fmt.Sprintf("_api/database/%s", name)
-	req, err := http.NewRequest("DELETE", url.String(), nil)
-	if err != nil {
-		return fmt.Errorf("error while dropping database: %w", err)
-	}
-
-	resp, err := c.Client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error while dropping database: %w", err)
-	}
-	if _, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
-		return fmt.Errorf("failed to read body: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("error while dropping database: unexpected status code: %d", resp.StatusCode)
-	}
-	return nil
+func (c *Context) dropDatabase(ctx context.Context, name string) error {
+	return c.request(ctx, "DELETE", fmt.Sprintf("_api/database/%s", name), nil, nil, 200)
 }
 
 func (c *Context) openDatabase(dbname string) *DatabaseContext {
@@ -218,60 +315,180 @@ type CreateCollectionOptions struct {
 	WaitForSync       bool   `json:"waitForSync"`
 }
 
-func (c *DatabaseContext) createCollection(opts CreateCollectionOptions) error {
+func (c *DatabaseContext) createCollection(ctx context.Context, opts CreateCollectionOptions) error {
+	return c.request(ctx, "POST", fmt.Sprintf("/_db/%s/_api/collection", c.Database), opts, nil, 200)
+}
+
+func (c *DatabaseContext) insertDocument(ctx context.Context, collection string, doc interface{}) error {
+	return c.request(ctx, "POST", fmt.Sprintf("/_db/%s/_api/document/c", c.Database), doc, nil, 201, 202)
+}
 
-	body, err := json.Marshal(opts)
-	if err != nil {
-		return fmt.Errorf("error while creating collection: %w", err)
+// request sends body to path and decodes the response into result,
+// if result is not nil. Responses with a status code other than the
+// expected ones are returned as *ArangoError, as are all other failures.
+// Failed requests are retried according to the retry policy of the context.
+func (c *Context) request(ctx context.Context, method, path string, body, result interface{}, expected ...int) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = c.encoding.marshal(body); err != nil {
+			return &ArangoError{Method: method, Path: path, Cause: err}
+		}
 	}
-	url := c.Endpoint
-	url.Path = fmt.Sprintf("/_db/%s/_api/collection", c.Database)
-	resp, err := c.Client.Post(url.String(), "application/json", bytes.NewReader(body))
+
+	return c.withRetry(ctx, func() error {
+		return c.send(ctx, method, path, data, result, expected)
+	})
+}
+
+func (c *Context) send(ctx context.Context, method, path string, data []byte, result interface{}, expected []int) error {
+	return c.sendAttempt(ctx, method, path, data, result, expected, true)
+}
+
+// sendAttempt sends the request once. Tokens are acquired before the request
+// is timed and traced. If reauthorize is set and the server rejects the
+// token, it is dropped and the request is sent once more with a new one.
+func (c *Context) sendAttempt(parent context.Context, method, path string, data []byte, result interface{}, expected []int, reauthorize bool) error {
+	fail := func(endpoint string, cause error) error {
+		return &ArangoError{Endpoint: endpoint, Method: method, Path: path, Cause: cause}
+	}
+
+	timeout := c.requestTimeout
+	if timeout <= 0 {
+		timeout = clientTimeout
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	if c.Auth != nil {
+		if err := c.Auth.prepare(ctx, c); err != nil {
+			return fail("", err)
+		}
+	}
+
+	start := time.Now()
+	trace := &requestTrace{}
+	ctx = httptrace.WithClientTrace(ctx, trace.clientTrace())
+	req, err := c.newRequest(ctx, method, path, data)
 	if err != nil {
-		return fmt.Errorf("error while creating collection: %w", err)
+		return fail("", err)
+	}
+	e := c.endpoints.acquire(c.worker)
+	req.URL.Scheme, req.URL.Host = e.URL.Scheme, e.URL.Host
+	requestStart := time.Now()
+	if c.Auth != nil {
+		c.Auth.authorize(req)
 	}
-	if _, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
-		return fmt.Errorf("failed to read body: %w", err)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		e.done(requestStart, true)
+		return fail(req.URL.Host, err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized && reauthorize && c.Auth != nil && c.Auth.invalidate() {
+		// the token has expired on the server side
+		io.Copy(ioutil.Discard, resp.Body)
+		e.done(requestStart, true)
+		return c.sendAttempt(parent, method, path, data, result, expected, false)
+	}
+
+	payload, err := ioutil.ReadAll(resp.Body)
+	e.done(requestStart, err != nil || resp.StatusCode >= 400)
+	if err != nil {
+		return fail(req.URL.Host, fmt.Errorf("failed to read body: %w", err))
+	}
+	atomic.AddInt64(&c.stats.bytesReceived, int64(len(payload)))
+	done := time.Now()
+	c.stats.record(trace, done)
+	c.stats.server.record(resp.Header, done.Sub(start))
+
+	if !containsStatus(expected, resp.StatusCode) {
+		e := &ArangoError{}
+		// the body is only informative, a broken one must not hide the status
+		unmarshalBody(resp.Header.Get("Content-Type"), payload, e)
+		e.StatusCode, e.Endpoint, e.Method, e.Path = resp.StatusCode, req.URL.Host, method, path
+		if e.ErrorMessage == "" {
+			e.ErrorMessage = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("error while creating collection: %d", resp.StatusCode)
+	if result != nil {
+		if err := unmarshalBody(resp.Header.Get("Content-Type"), payload, result); err != nil {
+			return fail(req.URL.Host, fmt.Errorf("error while reading the response: %w", err))
+		}
 	}
 	return nil
 }
 
-func (c *DatabaseContext) insertDocument(collection string, doc interface{}) error {
+func containsStatus(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
 
-	body, err := json.Marshal(doc)
-	if err != nil {
-		return fmt.Errorf("error while creating collection: %w", err)
+// newRequest creates a request for path. The endpoint is filled in by send.
+func (c *Context) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
 	}
-	url := c.Endpoint
-	url.Path = fmt.Sprintf("/_db/%s/_api/document/c", c.Database)
-	resp, err := c.Client.Post(url.String(), "application/json", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, method, "/"+strings.TrimPrefix(path, "/"), reader)
 	if err != nil {
-		return fmt.Errorf("error while creating document: %w", err)
+		return nil, err
 	}
-	if _, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
-		return fmt.Errorf("failed to read body: %w", err)
+	req.Header.Set("Accept", c.encoding.contentType())
+	if body != nil {
+		req.Header.Set("Content-Type", c.encoding.contentType())
 	}
-	defer resp.Body.Close()
+	return req, nil
+}
 
-	if resp.StatusCode != 201 && resp.StatusCode != 202 {
-		return fmt.Errorf("error while creating document: %d", resp.StatusCode)
-	}
-	return nil
+type ContextOptions struct {
+	Auth          Authentication
+	TLS           TLSOptions
+	LoadBalancing LoadBalancing
+	Retry         RetryPolicy
+	Protocol      Protocol
+	Encoding      Encoding
+	// Cassette records all requests or replays recorded ones.
+	Cassette Cassette
+	// Readiness are the conditions a replicated log has to satisfy before
+	// a test starts, by default a leader has to be elected.
+	Readiness []ReadinessCondition
 }
 
-func NewContext(endpoint *url.URL) *Context {
-	return &Context{
-		Client: &http.Client{
-			Transport: &http.Transport{
-				MaxIdleConnsPerHost: 1000,
-			},
-			Timeout: 30 * time.Second,
-		},
-		Endpoint: *endpoint,
+func NewContext(endpoints []url.URL, opts ContextOptions) (*Context, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoints given")
+	}
+
+	tlsConfig, err := opts.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Protocol == "" {
+		opts.Protocol = HTTP1
+	}
+	if opts.Encoding == "" {
+		opts.Encoding = JSON
+	}
+
+	c := &Context{
+		Auth:      opts.Auth,
+		tlsConfig: tlsConfig,
+		protocol:  opts.Protocol,
+		encoding:  opts.Encoding,
+		retry:     opts.Retry,
+		cassette:  opts.Cassette,
+		readiness: opts.Readiness,
+		endpoints: newEndpointPool(endpoints, opts.LoadBalancing),
+		stats:     &contextStats{},
+		worker:    -1,
 	}
+	c.Client = c.newClient(connectionOptions{})
+	return c, nil
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DisruptionResults describe how a workload was affected by disruptive
+// events, such as leader changes, that were triggered during a test.
+type DisruptionResults struct {
+	Events         float64 `json:"events"`
+	FailedRequests float64 `json:"failedRequests"`
+	// Unavailability is the distribution of the longest time without any
+	// successful request after each event.
+	Unavailability LatencyDistribution `json:"unavailability"`
+	// Before contains the requests started within the phase window before
+	// an event, During those started while the workload was unavailable
+	// and After those started within the phase window after it recovered.
+	Before LatencyDistribution `json:"before"`
+	During LatencyDistribution `json:"during"`
+	After  LatencyDistribution `json:"after"`
+	// Throughput is the number of successful requests per second completed
+	// in the respective phases.
+	Throughput DisruptionThroughput `json:"throughput"`
+}
+
+type DisruptionThroughput struct {
+	Before float64 `json:"before"`
+	During float64 `json:"during"`
+	After  float64 `json:"after"`
+}
+
+// phaseCounter counts the successful requests completed within the time
+// covered by a phase.
+type phaseCounter struct {
+	completed float64
+	duration  time.Duration
+}
+
+func (p *phaseCounter) add(succeeded []time.Time, from, to time.Time) {
+	if !to.After(from) {
+		return
+	}
+	p.duration += to.Sub(from)
+	for _, t := range succeeded {
+		if !t.Before(from) && t.Before(to) {
+			p.completed++
+		}
+	}
+}
+
+func (p *phaseCounter) rate() float64 {
+	if p.duration <= 0 {
+		return 0
+	}
+	return p.completed / p.duration.Seconds()
+}
+
+// DisruptionReporter is implemented by tests that disrupt their workload.
+// DisruptionResults is called after all test threads are done.
+type DisruptionReporter interface {
+	DisruptionResults(ctx context.Context) (DisruptionResults, error)
+}
+
+// defaultDisruptionInterval is used by disruption tests without an
+// interval.
+const defaultDisruptionInterval = 5 * time.Second
+
+// backgroundDisruptor runs a disruptive action every interval, from
+// SetupTest until the results are collected, while the test threads insert
+// entries into the log like ReplicatedLogsTest. Inserts that fail after all
+// retries are counted instead of failing the test. Tests embed it and
+// implement the action.
+type backgroundDisruptor struct {
+	recorder disruptionRecorder
+	quorums  quorumRecorder
+	// control sends the requests of the action, which are not part of the
+	// measured workload.
+	control  *Context
+	interval time.Duration
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	err      error
+}
+
+func disruptionInterval(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return defaultDisruptionInterval
+	}
+	return interval
+}
+
+// disruptionTestName names a test after its kind, its settings and the
+// interval of its disruptions. The suffix precedes the one for waitForSync.
+func disruptionTestName(kind string, test TestSettings, interval time.Duration, suffix string) string {
+	name := fmt.Sprintf("%s-c%d-r%d-wc%d-i%s%s", kind, test.NumberOfThreads, test.NumberOfServers, test.Config.WriteConcern, disruptionInterval(interval), suffix)
+	if test.Config.WaitForSync {
+		name = name + "-ws"
+	}
+	return name
+}
+
+// setUp creates the log of the test and starts calling tick every interval
+// once the log is ready. tick has to send its requests through d.control.
+// The first error of tick stops the disruptions and is returned by
+// DisruptionResults, errors after the test was stopped are ignored.
+func (d *backgroundDisruptor) setUp(ctx context.Context, c *Context, id uint, test TestSettings, interval time.Duration, tick func(ctx context.Context) error) error {
+	if err := c.createReplicatedLog(ctx, id, test.Config.logConfig()); err != nil {
+		return err
+	}
+
+	if err := c.waitForReplicatedLog(ctx, id); err != nil {
+		c.dropReplicatedLog(context.Background(), id)
+		return err
+	}
+
+	d.recorder.reset(test.NumberOfThreads, test.NumberOfRequests)
+	d.quorums.reset()
+	d.interval, d.err = disruptionInterval(interval), nil
+	d.control = c.unmeasured()
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.err = d.run(ctx, tick)
+	}()
+	return nil
+}
+
+// RunTestThread inserts entries and records them. Failed inserts do not stop
+// the thread, they are only counted by the recorder and get no latency in
+// results.
+func (d *backgroundDisruptor) RunTestThread(ctx context.Context, c *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error {
+	inserts := insertChecker{quorums: &d.quorums}
+	for k := 0; k < test.NumberOfRequests; k++ {
+		entry := LogEntry{threadNo, k}
+		if err := c.beforeRequest(ctx); err != nil {
+			return err
+		}
+		req_start := time.Now()
+		res, err := c.insertReplicatedLog(ctx, id, entry)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		req_end := time.Now()
+		d.recorder.record(threadNo, req_start, req_end, err != nil)
+		if err != nil {
+			continue
+		}
+		results[k] = req_end.Sub(req_start)
+		if err := inserts.check(res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *backgroundDisruptor) run(ctx context.Context, tick func(ctx context.Context) error) error {
+	for {
+		select {
+		case <-time.After(d.interval):
+		case <-ctx.Done():
+			return nil
+		}
+
+		if err := tick(ctx); err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+}
+
+// stop ends the disruptions, it may be called more than once.
+func (d *backgroundDisruptor) stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+	if d.control != nil {
+		d.control.Client.CloseIdleConnections()
+	}
+}
+
+func (d *backgroundDisruptor) DisruptionResults(ctx context.Context) (DisruptionResults, error) {
+	d.stop()
+	if d.err != nil {
+		return DisruptionResults{}, d.err
+	}
+	return d.recorder.results(d.interval / 4), nil
+}
+
+func (d *backgroundDisruptor) QuorumResults(ctx context.Context) (QuorumResults, error) {
+	return d.quorums.results(), nil
+}
+
+func (d *backgroundDisruptor) TearDownTest(ctx context.Context, c *Context, id uint) error {
+	d.stop()
+	return c.dropReplicatedLog(ctx, id)
+}
+
+type requestSample struct {
+	start, end time.Time
+	failed     bool
+}
+
+// disruptionRecorder collects the requests of the test threads and the
+// times of the disruptive events.
+type disruptionRecorder struct {
+	mutex   sync.Mutex
+	samples [][]requestSample
+	events  []time.Time
+}
+
+func (r *disruptionRecorder) reset(threads, requests int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.samples = make([][]requestSample, threads)
+	for i := range r.samples {
+		r.samples[i] = make([]requestSample, 0, requests)
+	}
+	r.events = nil
+}
+
+// record is called by the test threads, each thread only appends to its
+// own samples.
+func (r *disruptionRecorder) record(threadNo int, start, end time.Time, failed bool) {
+	r.samples[threadNo] = append(r.samples[threadNo], requestSample{start, end, failed})
+}
+
+func (r *disruptionRecorder) event(t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, t)
+}
+
+// results evaluates the samples, phaseWindow is the time before and after
+// every event that is considered for the Before and After distributions.
+func (r *disruptionRecorder) results(phaseWindow time.Duration) DisruptionResults {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var samples []requestSample
+	for _, s := range r.samples {
+		samples = append(samples, s...)
+	}
+	result := DisruptionResults{Events: float64(len(r.events))}
+	var succeeded []time.Time
+	var end time.Time
+	for _, s := range samples {
+		if s.failed {
+			result.FailedRequests++
+		} else {
+			succeeded = append(succeeded, s.end)
+		}
+		if s.end.After(end) {
+			end = s.end
+		}
+	}
+	sort.Slice(succeeded, func(a, b int) bool { return succeeded[a].Before(succeeded[b]) })
+
+	var unavailability, before, during, after []time.Duration
+	var beforeCount, duringCount, afterCount phaseCounter
+	for i, event := range r.events {
+		next := end
+		if i+1 < len(r.events) {
+			next = r.events[i+1]
+		}
+
+		// find the longest gap between successful requests after the event
+		var gap time.Duration
+		recovered, previous := next, event
+		for _, t := range succeeded {
+			if t.Before(event) {
+				continue
+			}
+			if !t.Before(next) {
+				break
+			}
+			if t.Sub(previous) > gap {
+				gap, recovered = t.Sub(previous), t
+			}
+			previous = t
+		}
+		if next.Sub(previous) > gap {
+			gap, recovered = next.Sub(previous), next
+		}
+		unavailability = append(unavailability, gap)
+
+		previousEvent := time.Time{}
+		if i > 0 {
+			previousEvent = r.events[i-1]
+		}
+		beforeCount.add(succeeded, latest(event.Add(-phaseWindow), previousEvent), event)
+		duringCount.add(succeeded, event, recovered)
+		afterCount.add(succeeded, recovered, earliest(recovered.Add(phaseWindow), next))
+
+		for _, s := range samples {
+			latency := s.end.Sub(s.start)
+			switch {
+			case !s.start.Before(event.Add(-phaseWindow)) && s.start.Before(event):
+				before = append(before, latency)
+			case !s.start.Before(event) && s.start.Before(recovered):
+				during = append(during, latency)
+			case !s.start.Before(recovered) && s.start.Before(recovered.Add(phaseWindow)) && s.start.Before(next):
+				after = append(after, latency)
+			}
+		}
+	}
+
+	result.Unavailability = calcDistribution(unavailability)
+	result.Before = calcDistribution(before)
+	result.During = calcDistribution(during)
+	result.After = calcDistribution(after)
+	result.Throughput = DisruptionThroughput{
+		Before: beforeCount.rate(),
+		During: duringCount.rate(),
+		After:  afterCount.rate(),
+	}
+	return result
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
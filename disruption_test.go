@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDisruptionControlRequestsAreUnmeasured(t *testing.T) {
+	const id = 3
+	test := TestSettings{NumberOfThreads: 1, NumberOfServers: 2, Config: Config{WriteConcern: 1}}
+	r := &cassetteRecorder{t: t}
+	r.add("POST", "/_api/log", nil, 200, map[string]interface{}{})
+	r.add("GET", "/_api/log/3", nil, 200, participantsStatusResponse("PRMR-1", "PRMR-1", "PRMR-2"))
+	// a single tick, the next one fails since nothing is left to replay
+	r.add("GET", "/_api/log/3", nil, 200, participantsStatusResponse("PRMR-1", "PRMR-1", "PRMR-2"))
+
+	c, _ := r.replay(ContextOptions{})
+	var d backgroundDisruptor
+	// the ticks wait until the requests of the setup are no longer counted
+	started := make(chan struct{})
+	ticks := 0
+	err := d.setUp(context.Background(), c, id, test, 10*time.Millisecond, func(ctx context.Context) error {
+		<-started
+		ticks++
+		_, err := d.control.GetReplicatedLogStatus(ctx, id)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.stats.reset()
+	c.endpoints.reset()
+	close(started)
+	d.wg.Wait()
+
+	_, err = d.DisruptionResults(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "no recorded response left for GET /_api/log/3") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if ticks != 2 {
+		t.Errorf("%d ticks, expected 2", ticks)
+	}
+	if c.stats.bytesReceived != 0 || c.endpoints.results()["replay.invalid:8529"].Requests != 0 {
+		t.Errorf("control requests were counted: %d bytes, %+v", c.stats.bytesReceived, c.endpoints.results())
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"time"
@@ -27,8 +28,8 @@ func randSeq(n int) string {
 	return string(b)
 }
 
-func (s *DocumentTests) RunTestThread(ctx *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error {
-	dbctx := ctx.openDatabase(s.dbname)
+func (s *DocumentTests) RunTestThread(ctx context.Context, c *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error {
+	dbctx := c.openDatabase(s.dbname)
 	value := randSeq(int(test.Config.DocumentSize))
 	entries := make([]MyDocument, test.Config.BatchSize)
 	for k := 0; k < test.NumberOfRequests; k++ {
@@ -36,9 +37,12 @@ func (s *DocumentTests) RunTestThread(ctx *Context, id uint, test TestSettings,
 			entries[j] = MyDocument{value, threadNo, k, j}
 		}
 
+		if err := c.beforeRequest(ctx); err != nil {
+			return err
+		}
 		req_start := time.Now()
-		if err := dbctx.insertDocument(CollectionName, entries); err != nil {
-			return fmt.Errorf("failed to insert document during test: %v", err)
+		if err := dbctx.insertDocument(ctx, CollectionName, entries); err != nil {
+			return fmt.Errorf("failed to insert document during test: %w", err)
 		}
 		results[k] = time.Since(req_start)
 	}
@@ -46,6 +50,10 @@ func (s *DocumentTests) RunTestThread(ctx *Context, id uint, test TestSettings,
 	return nil
 }
 
+func (DocumentTests) EntriesPerRequest(test TestSettings) int {
+	return int(test.Config.BatchSize)
+}
+
 func (DocumentTests) GetTestName(test TestSettings) string {
 	name := fmt.Sprintf("doc-insert-c%d-r%d-wc%d-s%d", test.NumberOfThreads, test.NumberOfServers,
 		test.Config.WriteConcern, test.Config.NumberOfShards)
@@ -64,14 +72,14 @@ func (DocumentTests) GetTestName(test TestSettings) string {
 
 const CollectionName string = "c"
 
-func (s *DocumentTests) SetupTest(ctx *Context, id uint, test TestSettings) error {
+func (s *DocumentTests) SetupTest(ctx context.Context, c *Context, id uint, test TestSettings) error {
 	// create replication 2 database and create a collection
 	s.dbname = s.GetTestName(test)
-	if err := ctx.createDatabase(s.dbname, &DatabaseOptions{ReplicationVersion: &test.Config.ReplicationVersion}); err != nil {
+	if err := c.createDatabase(ctx, s.dbname, &DatabaseOptions{ReplicationVersion: &test.Config.ReplicationVersion}); err != nil {
 		return fmt.Errorf("failed to setup test; could not create database %s: %v", s.dbname, err)
 	}
 
-	db := ctx.openDatabase(s.dbname)
+	db := c.openDatabase(s.dbname)
 
 	opts := CreateCollectionOptions{
 		Name:              CollectionName,
@@ -80,14 +88,14 @@ func (s *DocumentTests) SetupTest(ctx *Context, id uint, test TestSettings) erro
 		NumberOfShards:    test.Config.NumberOfShards,
 		WaitForSync:       test.Config.WaitForSync,
 	}
-	if err := db.createCollection(opts); err != nil {
+	if err := db.createCollection(ctx, opts); err != nil {
 		return fmt.Errorf("failed to create collection: %v", err)
 	}
 
 	return nil
 }
 
-func (s *DocumentTests) TearDownTest(ctx *Context, id uint) error {
+func (s *DocumentTests) TearDownTest(ctx context.Context, c *Context, id uint) error {
 	// drop database
-	return ctx.dropDatabase(s.dbname)
+	return c.dropDatabase(ctx, s.dbname)
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type Encoding string
+
+const (
+	JSON  Encoding = "json"
+	VPack Encoding = "vpack"
+)
+
+func parseEncoding(s string) (Encoding, error) {
+	switch e := Encoding(s); e {
+	case JSON, VPack:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q", s)
+}
+
+func (e Encoding) contentType() string {
+	if e == VPack {
+		return vstContentVelocypack
+	}
+	return "application/json"
+}
+
+func (e Encoding) marshal(v interface{}) ([]byte, error) {
+	if e == VPack {
+		return vpackAppend(nil, v)
+	}
+	return json.Marshal(v)
+}
+
+// unmarshalBody decodes a response body according to its content type.
+func unmarshalBody(contentType string, data []byte, v interface{}) error {
+	if strings.Contains(contentType, vstContentVelocypack) {
+		return vpackUnmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// withEncoding returns a copy of the context that sends and accepts bodies
+// in the given encoding.
+func (c *Context) withEncoding(encoding Encoding) *Context {
+	result := *c
+	result.encoding = encoding
+	return &result
+}
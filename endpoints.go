@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type LoadBalancing string
+
+const (
+	RoundRobin    LoadBalancing = "round-robin"
+	StickyWorker  LoadBalancing = "sticky"
+	RandomChoice  LoadBalancing = "random"
+	LeastInFlight LoadBalancing = "least-in-flight"
+)
+
+func parseLoadBalancing(s string) (LoadBalancing, error) {
+	switch lb := LoadBalancing(s); lb {
+	case RoundRobin, StickyWorker, RandomChoice, LeastInFlight:
+		return lb, nil
+	}
+	return "", fmt.Errorf("unknown load balancing strategy %q", s)
+}
+
+type endpoint struct {
+	URL url.URL
+
+	inFlight int64
+	requests int64
+	errors   int64
+	latency  int64
+}
+
+func (e *endpoint) done(start time.Time, failed bool) {
+	atomic.AddInt64(&e.inFlight, -1)
+	atomic.AddInt64(&e.requests, 1)
+	atomic.AddInt64(&e.latency, int64(time.Since(start)))
+	if failed {
+		atomic.AddInt64(&e.errors, 1)
+	}
+}
+
+type CoordinatorResult struct {
+	Requests   float64 `json:"requests"`
+	Errors     float64 `json:"errors"`
+	AvgLatency float64 `json:"avg"`
+}
+
+// endpointPool distributes requests over a set of coordinators.
+type endpointPool struct {
+	mutex     sync.Mutex
+	endpoints []*endpoint
+	strategy  LoadBalancing
+	next      uint64
+	// version counts the changes of the endpoints. A pool with a source
+	// takes over the endpoints of the source whenever they changed, seen is
+	// the version of the source it took over last.
+	version uint64
+	source  *endpointPool
+	seen    uint64
+}
+
+func newEndpointPool(urls []url.URL, strategy LoadBalancing) *endpointPool {
+	p := &endpointPool{strategy: strategy}
+	p.set(urls)
+	return p
+}
+
+// set replaces the endpoints of the pool. Endpoints that are already known
+// keep their counters.
+func (p *endpointPool) set(urls []url.URL) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.version++
+	p.replace(urls)
+}
+
+// replace sets the endpoints, the caller has to hold the mutex.
+func (p *endpointPool) replace(urls []url.URL) {
+	known := make(map[url.URL]*endpoint)
+	for _, e := range p.endpoints {
+		known[e.URL] = e
+	}
+
+	endpoints := make([]*endpoint, len(urls))
+	for i, u := range urls {
+		u = *normalizeEndpoint(&u)
+		if e, ok := known[u]; ok {
+			endpoints[i] = e
+		} else {
+			endpoints[i] = &endpoint{URL: u}
+		}
+	}
+	p.endpoints = endpoints
+}
+
+// clone returns a pool with the same endpoints and strategy, but counters
+// of its own. The clone follows later changes of the endpoints of p.
+func (p *endpointPool) clone() *endpointPool {
+	return &endpointPool{strategy: p.strategy, source: p}
+}
+
+func (p *endpointPool) list() []*endpoint {
+	endpoints, _ := p.state()
+	return endpoints
+}
+
+// state returns the endpoints together with their version.
+func (p *endpointPool) state() ([]*endpoint, uint64) {
+	if p.source != nil {
+		p.follow()
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.endpoints, p.version
+}
+
+// follow takes over the endpoints of the source if they changed since the
+// last call.
+func (p *endpointPool) follow() {
+	endpoints, version := p.source.state()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.endpoints != nil && version == p.seen {
+		return
+	}
+	urls := make([]url.URL, len(endpoints))
+	for i, e := range endpoints {
+		urls[i] = e.URL
+	}
+	p.version++
+	p.replace(urls)
+	p.seen = version
+}
+
+// acquire selects the endpoint for the next request of the given worker.
+// Requests that do not belong to a worker thread pass a negative number.
+// The caller has to call done on the returned endpoint.
+func (p *endpointPool) acquire(worker int) *endpoint {
+	e := p.pick(worker)
+	atomic.AddInt64(&e.inFlight, 1)
+	return e
+}
+
+// pick selects an endpoint like acquire, but the request is not counted.
+func (p *endpointPool) pick(worker int) *endpoint {
+	endpoints := p.list()
+	var e *endpoint
+	switch {
+	case len(endpoints) == 1:
+		e = endpoints[0]
+	case p.strategy == StickyWorker && worker >= 0:
+		e = endpoints[worker%len(endpoints)]
+	case p.strategy == RandomChoice:
+		e = endpoints[rand.Intn(len(endpoints))]
+	case p.strategy == LeastInFlight:
+		e = endpoints[0]
+		for _, other := range endpoints[1:] {
+			if atomic.LoadInt64(&other.inFlight) < atomic.LoadInt64(&e.inFlight) {
+				e = other
+			}
+		}
+	default:
+		e = endpoints[atomic.AddUint64(&p.next, 1)%uint64(len(endpoints))]
+	}
+	return e
+}
+
+func (p *endpointPool) topology() []string {
+	endpoints := p.list()
+	result := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		result[i] = e.URL.String()
+	}
+	return result
+}
+
+func (p *endpointPool) reset() {
+	for _, e := range p.list() {
+		atomic.StoreInt64(&e.requests, 0)
+		atomic.StoreInt64(&e.errors, 0)
+		atomic.StoreInt64(&e.latency, 0)
+	}
+}
+
+func (p *endpointPool) results() map[string]CoordinatorResult {
+	results := make(map[string]CoordinatorResult)
+	for _, e := range p.list() {
+		requests := atomic.LoadInt64(&e.requests)
+		result := CoordinatorResult{
+			Requests: float64(requests),
+			Errors:   float64(atomic.LoadInt64(&e.errors)),
+		}
+		if requests > 0 {
+			result.AvgLatency = (time.Duration(atomic.LoadInt64(&e.latency)) / time.Duration(requests)).Seconds()
+		}
+		results[e.URL.Host] = result
+	}
+	return results
+}
+
+func (c *Context) discoverEndpoints(ctx context.Context) ([]url.URL, error) {
+	var target struct {
+		Endpoints []struct {
+			Endpoint string `json:"endpoint"`
+		} `json:"endpoints"`
+	}
+	const path = "_api/cluster/endpoints"
+	if err := c.request(ctx, "GET", path, nil, &target, 200); err != nil {
+		return nil, err
+	}
+	if len(target.Endpoints) == 0 {
+		return nil, &ArangoError{Method: "GET", Path: path, Cause: fmt.Errorf("server reported no endpoints")}
+	}
+
+	urls := make([]url.URL, len(target.Endpoints))
+	for i, e := range target.Endpoints {
+		u, err := url.Parse(e.Endpoint)
+		if err != nil {
+			return nil, &ArangoError{Method: "GET", Path: path, Cause: fmt.Errorf("server reported invalid endpoint %s: %w", e.Endpoint, err)}
+		}
+		urls[i] = *u
+	}
+	return urls, nil
+}
+
+// refreshEndpoints replaces the coordinator pool by the endpoints reported
+// from the cluster.
+func (c *Context) refreshEndpoints(ctx context.Context) error {
+	urls, err := c.discoverEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+	c.endpoints.set(urls)
+	return nil
+}
+
+// startEndpointDiscovery refreshes the coordinator pool every interval until
+// ctx is done or the returned function is called. The discovery requests are
+// sent through an unmeasured context, so they are not counted in the results
+// of the test that is running meanwhile.
+func (c *Context) startEndpointDiscovery(ctx context.Context, interval time.Duration) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	ticker := time.NewTicker(interval)
+	dc := c.unmeasured()
+	go func() {
+		defer ticker.Stop()
+		defer dc.Client.CloseIdleConnections()
+		for {
+			select {
+			case <-ticker.C:
+				urls, err := dc.discoverEndpoints(ctx)
+				if err == nil {
+					c.endpoints.set(urls)
+				} else if ctx.Err() == nil {
+					fmt.Fprintf(os.Stderr, "Failed to refresh endpoints: %v\n", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUnmeasuredFollowsEndpoints(t *testing.T) {
+	pool := newEndpointPool([]url.URL{{Scheme: "http", Host: "a:8529"}}, RoundRobin)
+	clone := pool.clone()
+	if hosts := clone.topology(); len(hosts) != 1 || hosts[0] != "http://a:8529" {
+		t.Errorf("clone has endpoints %v", hosts)
+	}
+	clone.acquire(-1).done(time.Now(), false)
+	pool.set([]url.URL{{Scheme: "http", Host: "a:8529"}, {Scheme: "http", Host: "b:8529"}})
+	if hosts := clone.topology(); len(hosts) != 2 || hosts[1] != "http://b:8529" {
+		t.Errorf("clone did not follow the endpoints, has %v", hosts)
+	}
+	if r := clone.results()["a:8529"]; r.Requests != 1 {
+		t.Errorf("clone lost its counters: %+v", r)
+	}
+	if r := pool.results()["a:8529"]; r.Requests != 0 {
+		t.Errorf("request of the clone was counted: %+v", r)
+	}
+}
+
+func TestEndpointDiscoveryIsUnmeasured(t *testing.T) {
+	var requests int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"endpoints":[{"endpoint":%q}]}`, server.URL)
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := c.startEndpointDiscovery(context.Background(), 10*time.Millisecond)
+	defer stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&requests) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d discovery requests within 5s, expected 3", atomic.LoadInt32(&requests))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if bytes := atomic.LoadInt64(&c.stats.bytesReceived); bytes != 0 || c.endpoints.results()[endpoint.Host].Requests != 0 {
+		t.Errorf("discovery was counted: %d bytes, %+v", bytes, c.endpoints.results())
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Error numbers as defined in arangod's errors.dat.
+const (
+	ErrorLockTimeout              = 18
+	ErrorShuttingDown             = 30
+	ErrorArangoConflict           = 1200
+	ErrorDocumentNotFound         = 1202
+	ErrorDataSourceNotFound       = 1203
+	ErrorDuplicateName            = 1207
+	ErrorUniqueConstraintViolated = 1210
+	ErrorDatabaseNotFound         = 1228
+	ErrorReplicatedLogNotFound    = 1418
+	ErrorReplicatedLogNotLeader   = 1419
+	ErrorReplicatedLogNotFollower = 1420
+	ErrorReplicatedLogResigned    = 1422
+	ErrorClusterTimeout           = 1457
+	ErrorClusterBackendDown       = 1478
+	ErrorLeadershipChallenge      = 1495
+	ErrorClusterNotLeader         = 1496
+)
+
+// ArangoError is returned by all Context methods. It either describes an
+// error response of the server or, if Cause is set, a failure to talk to
+// the server at all.
+type ArangoError struct {
+	StatusCode   int    `json:"code"`
+	ErrorNum     int    `json:"errorNum"`
+	ErrorMessage string `json:"errorMessage"`
+	Endpoint     string `json:"-"`
+	Method       string `json:"-"`
+	Path         string `json:"-"`
+	Cause        error  `json:"-"`
+}
+
+func (e *ArangoError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s %s on %s failed: %v", e.Method, e.Path, e.Endpoint, e.Cause)
+	}
+	return fmt.Sprintf("%s %s on %s failed: status-code=%d, error-code=%d, message=%s",
+		e.Method, e.Path, e.Endpoint, e.StatusCode, e.ErrorNum, e.ErrorMessage)
+}
+
+func (e *ArangoError) Unwrap() error {
+	return e.Cause
+}
+
+func asArangoError(err error) (*ArangoError, bool) {
+	var ae *ArangoError
+	if errors.As(err, &ae) {
+		return ae, true
+	}
+	return nil, false
+}
+
+// hasErrorNum reports whether err is a server error with one of the nums.
+func hasErrorNum(err error, nums ...int) bool {
+	ae, ok := asArangoError(err)
+	if !ok || ae.Cause != nil {
+		return false
+	}
+	for _, num := range nums {
+		if ae.ErrorNum == num {
+			return true
+		}
+	}
+	return false
+}
+
+func hasStatusCode(err error, codes ...int) bool {
+	ae, ok := asArangoError(err)
+	if !ok || ae.Cause != nil {
+		return false
+	}
+	for _, code := range codes {
+		if ae.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+func IsConflict(err error) bool {
+	return hasStatusCode(err, http.StatusConflict) ||
+		hasErrorNum(err, ErrorArangoConflict, ErrorDuplicateName, ErrorUniqueConstraintViolated)
+}
+
+func IsNotFound(err error) bool {
+	return hasStatusCode(err, http.StatusNotFound) ||
+		hasErrorNum(err, ErrorDocumentNotFound, ErrorDataSourceNotFound, ErrorDatabaseNotFound, ErrorReplicatedLogNotFound)
+}
+
+// IsLeaderChanged reports whether the request was rejected because the
+// addressed server is not, or no longer, the leader.
+func IsLeaderChanged(err error) bool {
+	return hasErrorNum(err, ErrorReplicatedLogNotLeader, ErrorReplicatedLogNotFollower, ErrorReplicatedLogResigned,
+		ErrorLeadershipChallenge, ErrorClusterNotLeader)
+}
+
+func IsTimeout(err error) bool {
+	if hasStatusCode(err, http.StatusRequestTimeout, http.StatusGatewayTimeout) ||
+		hasErrorNum(err, ErrorLockTimeout, ErrorClusterTimeout) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// IsConnectionError reports whether the request failed in the transport
+// before a response was received, e.g. because the connection was refused
+// or reset. Timeouts of the request itself are not included.
+func IsConnectionError(err error) bool {
+	ae, ok := asArangoError(err)
+	if !ok || ae.Cause == nil || ae.Endpoint == "" {
+		return false
+	}
+	var ue *url.Error
+	return errors.As(ae.Cause, &ue) && !errors.Is(ue, context.DeadlineExceeded)
+}
+
+// IsUnavailable reports whether the server is temporarily unable to serve
+// the request.
+func IsUnavailable(err error) bool {
+	return hasStatusCode(err, http.StatusServiceUnavailable) ||
+		hasErrorNum(err, ErrorShuttingDown, ErrorClusterBackendDown)
+}
@@ -1,3 +1,7 @@
 module maierlars/replicated-logs-perf-test
 
-go 1.17
\ No newline at end of file
+go 1.17
+
+require golang.org/x/net v0.7.0
+
+require golang.org/x/text v0.7.0 // indirect
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"reflect"
 	"sort"
 	"time"
@@ -11,6 +12,10 @@ type TestSettings struct {
 	NumberOfThreads  int    `json:"numberOfThreads"`
 	NumberOfServers  uint   `json:"numberOfServers"`
 	Config           Config `json:"config"`
+	// ConnectionMode defaults to the mode given on the command line,
+	// ConnectionPoolSize is only used by PooledConnections.
+	ConnectionMode     ConnectionMode `json:"connectionMode,omitempty"`
+	ConnectionPoolSize int            `json:"connectionPoolSize,omitempty"`
 }
 
 type TestResult struct {
@@ -32,9 +37,85 @@ type TestResult struct {
 
 	RequsterPerSecond float64 `json:"rps"`
 	Total             float64 `json:"total"`
+	// EntriesPerSecond counts every entry or document of a batch.
+	EntriesPerSecond float64 `json:"eps"`
+	// BytesPerSecond counts the response bodies received by the test
+	// threads, requests beside the workload such as those of consumers are
+	// not included.
+	BytesPerSecond float64 `json:"bps"`
+
+	TLSHandshakes    float64 `json:"tlsHandshakes"`
+	TLSHandshakeTime float64 `json:"tlsHandshakeTime"`
+
+	Retries   float64 `json:"retries"`
+	RetryTime float64 `json:"retryTime"`
+
+	Connections float64 `json:"connections"`
+
+	RateLimit RateLimitResults `json:"rateLimit"`
+
+	Phases PhaseResults  `json:"phases"`
+	Server ServerResults `json:"server"`
+
+	Coordinators map[string]CoordinatorResult `json:"coordinators,omitempty"`
+
+	Latencies  map[string]LatencyDistribution `json:"latencies,omitempty"`
+	Disruption *DisruptionResults             `json:"disruption,omitempty"`
+	Quorum     *QuorumResults                 `json:"quorum,omitempty"`
+}
+
+type LatencyDistribution struct {
+	Count       float64 `json:"count"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Avg         float64 `json:"avg"`
+	Median      float64 `json:"med"`
+	Percent90   float64 `json:"p90"`
+	Percent99   float64 `json:"p99"`
+	Percent99p9 float64 `json:"p99.9"`
 }
 
+func calcDistribution(samples []time.Duration) LatencyDistribution {
+	nr := len(samples)
+	if nr == 0 {
+		return LatencyDistribution{}
+	}
+
+	sort.Slice(samples, func(a, b int) bool {
+		return samples[a] < samples[b]
+	})
+
+	sum := time.Duration(0)
+	for _, sample := range samples {
+		sum += sample
+	}
+
+	return LatencyDistribution{
+		Count:       float64(nr),
+		Min:         samples[0].Seconds(),
+		Max:         samples[nr-1].Seconds(),
+		Avg:         (sum / time.Duration(nr)).Seconds(),
+		Median:      samples[int(float64(nr)*0.5)].Seconds(),
+		Percent90:   samples[int(float64(nr)*0.9)].Seconds(),
+		Percent99:   samples[int(float64(nr)*0.99)].Seconds(),
+		Percent99p9: samples[int(float64(nr)*0.999)].Seconds(),
+	}
+}
+
+// calcResults evaluates the latencies of the requests. Requests that failed
+// without failing the test have no latency and are left out.
 func calcResults(total time.Duration, requests []time.Duration) TestResult {
+	succeeded := requests[:0]
+	for _, d := range requests {
+		if d > 0 {
+			succeeded = append(succeeded, d)
+		}
+	}
+	requests = succeeded
+	if len(requests) == 0 {
+		return TestResult{Total: total.Seconds()}
+	}
+
 	sort.Slice(requests, func(a, b int) bool {
 		return int64(requests[a]) < int64(requests[b])
 	})
@@ -71,12 +152,38 @@ func calcResults(total time.Duration, requests []time.Duration) TestResult {
 
 func collectMedians(results []TestResult) TestResult {
 	var result TestResult
-	l := len(results)
-	t := reflect.TypeOf(result)
+	runs := make([]reflect.Value, len(results))
+	for k := range results {
+		runs[k] = reflect.ValueOf(results[k])
+	}
+	collectMedianFields(reflect.ValueOf(&result).Elem(), runs)
+	return result
+}
+
+// collectMedianFields sets every float field of the struct result to the
+// median of that field over all runs, descending into nested structs.
+func collectMedianFields(result reflect.Value, runs []reflect.Value) {
+	l := len(runs)
+	t := result.Type()
 	for i := 0; i < t.NumField(); i++ {
+		switch t.Field(i).Type.Kind() {
+		case reflect.Float64:
+		case reflect.Struct:
+			fields := make([]reflect.Value, l)
+			for k := 0; k < l; k++ {
+				fields[k] = runs[k].Field(i)
+			}
+			collectMedianFields(result.Field(i), fields)
+			continue
+		default:
+			// values that are not plain numbers are taken from the last run
+			result.Field(i).Set(runs[l-1].Field(i))
+			continue
+		}
+
 		values := make([]float64, l)
 		for k := 0; k < l; k++ {
-			values[k] = reflect.ValueOf(results[k]).Field(i).Float()
+			values[k] = runs[k].Field(i).Float()
 		}
 
 		sort.Slice(values, func(a, b int) bool {
@@ -85,15 +192,37 @@ func collectMedians(results []TestResult) TestResult {
 
 		median := values[len(values)/2]
 
-		reflect.ValueOf(&result).Elem().Field(i).SetFloat(median)
+		result.Field(i).SetFloat(median)
 	}
-
-	return result
 }
 
 type TestImplementation interface {
 	GetTestName(test TestSettings) string
-	SetupTest(ctx *Context, id uint, test TestSettings) error
-	TearDownTest(ctx *Context, id uint) error
-	RunTestThread(ctx *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error
+	SetupTest(ctx context.Context, c *Context, id uint, test TestSettings) error
+	TearDownTest(ctx context.Context, c *Context, id uint) error
+	RunTestThread(ctx context.Context, c *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error
+}
+
+// ReplicatedLogTest is implemented by tests that write to the replicated
+// log with the id of the test, by embedding replicatedLogMarker. The final
+// state of the log is reported along with the results.
+type ReplicatedLogTest interface {
+	replicatedLog()
+}
+
+type replicatedLogMarker struct{}
+
+func (replicatedLogMarker) replicatedLog() {}
+
+// BatchingTest is implemented by tests that write more than one entry per
+// request. Tests without it write a single entry per request.
+type BatchingTest interface {
+	EntriesPerRequest(test TestSettings) int
+}
+
+// LatencyReporter is implemented by tests that measure latencies besides
+// the one of their requests. Latencies is called after all test threads are
+// done and returns the distributions by name.
+type LatencyReporter interface {
+	Latencies(ctx context.Context) (map[string]LatencyDistribution, error)
 }
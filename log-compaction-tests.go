@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReplicatedLogCompactionTest inserts entries, usually at a fixed rate,
+// while the log is released up to its commit index every Interval, which
+// allows the participants to compact it. Without Release the same points in
+// time are recorded but nothing is released, which gives the baseline.
+type ReplicatedLogCompactionTest struct {
+	replicatedLogMarker
+	backgroundDisruptor
+
+	Interval time.Duration
+	Release  bool
+
+	releases []time.Duration
+}
+
+func (s *ReplicatedLogCompactionTest) GetTestName(test TestSettings) string {
+	suffix := ""
+	if !s.Release {
+		suffix = "-baseline"
+	}
+	return disruptionTestName("release", test, s.Interval, suffix)
+}
+
+func (s *ReplicatedLogCompactionTest) SetupTest(ctx context.Context, c *Context, id uint, test TestSettings) error {
+	s.releases = nil
+	return s.setUp(ctx, c, id, test, s.Interval, func(ctx context.Context) error {
+		return s.release(ctx, s.control, id)
+	})
+}
+
+// release releases the log up to its commit index. The baseline only
+// records the event.
+func (s *ReplicatedLogCompactionTest) release(ctx context.Context, c *Context, id uint) error {
+	start := time.Now()
+	s.recorder.event(start)
+	if !s.Release {
+		return nil
+	}
+	status, err := c.GetReplicatedLogStatus(ctx, id)
+	if err == nil {
+		err = c.releaseReplicatedLog(ctx, id, status.CommitIndex)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release log: %w", err)
+	}
+	s.releases = append(s.releases, time.Since(start))
+	return nil
+}
+
+// Latencies reports the time it took to release the log, the baseline has
+// none.
+func (s *ReplicatedLogCompactionTest) Latencies(ctx context.Context) (map[string]LatencyDistribution, error) {
+	s.stop()
+	if !s.Release {
+		return nil, nil
+	}
+	return map[string]LatencyDistribution{"release": calcDistribution(s.releases)}, nil
+}
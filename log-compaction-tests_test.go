@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReleaseUpToCommitIndex(t *testing.T) {
+	r := &cassetteRecorder{t: t}
+	r.add("GET", "/_api/log/3", nil, 200, committedStatusResponse("PRMR-1", 5, "PRMR-1", "PRMR-2"))
+	r.add("POST", "/_api/log/3/release?index=5", nil, 200, map[string]interface{}{})
+
+	c, cassette := r.replay(ContextOptions{})
+	impl := &ReplicatedLogCompactionTest{Release: true}
+	if err := impl.release(context.Background(), c, 3); err != nil {
+		t.Fatal(err)
+	}
+	latencies, _ := impl.Latencies(context.Background())
+	if latencies["release"].Count != 1 {
+		t.Errorf("unexpected latencies %+v", latencies)
+	}
+	if unplayed := cassette.unplayed(); len(unplayed) > 0 {
+		t.Errorf("requests were not sent: %v", unplayed)
+	}
+}
+
+func TestReleaseBaselineSendsNoRequests(t *testing.T) {
+	// any request fails since the cassette is empty
+	c, _ := (&cassetteRecorder{t: t}).replay(ContextOptions{})
+	impl := &ReplicatedLogCompactionTest{}
+	if err := impl.release(context.Background(), c, 3); err != nil {
+		t.Fatal(err)
+	}
+	if events := impl.recorder.results(time.Second).Events; events != 1 {
+		t.Errorf("%v releases, expected 1", events)
+	}
+	if latencies, _ := impl.Latencies(context.Background()); latencies != nil {
+		t.Errorf("unexpected latencies %+v", latencies)
+	}
+}
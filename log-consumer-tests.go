@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	consumerPollLimit = 1000
+	// consumerDrainTimeout bounds the time consumers may need to see the
+	// last entries after all producers are done.
+	consumerDrainTimeout = 30 * time.Second
+)
+
+// TimestampedLogEntry carries the time its insert was started.
+type TimestampedLogEntry struct {
+	Client    int   `json:"client"`
+	Index     int   `json:"index"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// ReplicatedLogConsumerTest inserts timestamped entries with the test
+// threads while Consumers follow the log. Besides the insert latency it
+// reports the visibility latency, the time from starting an insert until a
+// consumer received the entry.
+type ReplicatedLogConsumerTest struct {
+	replicatedLogMarker
+
+	Consumers int
+
+	quorums quorumRecorder
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mutex   sync.Mutex
+	samples []time.Duration
+	errs    []error
+}
+
+func (s *ReplicatedLogConsumerTest) consumers() int {
+	if s.Consumers <= 0 {
+		return 1
+	}
+	return s.Consumers
+}
+
+func (s *ReplicatedLogConsumerTest) RunTestThread(ctx context.Context, c *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error {
+	inserts := insertChecker{quorums: &s.quorums}
+	for k := 0; k < test.NumberOfRequests; k++ {
+		if err := c.beforeRequest(ctx); err != nil {
+			return err
+		}
+		req_start := time.Now()
+		entry := TimestampedLogEntry{threadNo, k, req_start.UnixNano()}
+		res, err := c.insertReplicatedLog(ctx, id, entry)
+		if err != nil {
+			return fmt.Errorf("failed to insert log entry during test: %w", err)
+		}
+		results[k] = time.Since(req_start)
+		if err := inserts.check(res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *ReplicatedLogConsumerTest) GetTestName(test TestSettings) string {
+	name := fmt.Sprintf("follow-c%d-k%d-r%d-wc%d", test.NumberOfThreads, s.consumers(), test.NumberOfServers, test.Config.WriteConcern)
+	if test.Config.WaitForSync {
+		name = name + "-ws"
+	}
+	return name
+}
+
+func (s *ReplicatedLogConsumerTest) SetupTest(ctx context.Context, c *Context, id uint, test TestSettings) error {
+	s.quorums.reset()
+	if err := c.createReplicatedLog(ctx, id, test.Config.logConfig()); err != nil {
+		return err
+	}
+
+	status, err := func() (*ReplicatedLogStatus, error) {
+		if err := c.waitForReplicatedLog(ctx, id); err != nil {
+			return nil, err
+		}
+		return c.GetReplicatedLogStatus(ctx, id)
+	}()
+	if err != nil {
+		c.dropReplicatedLog(context.Background(), id)
+		return err
+	}
+
+	s.samples, s.errs = nil, nil
+	consumerCtx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	expected := test.NumberOfRequests * test.NumberOfThreads
+	// the polls of the consumers are not part of the measured workload
+	cc := c.unmeasured()
+	s.wg.Add(s.consumers())
+	go func() {
+		s.wg.Wait()
+		cc.Client.CloseIdleConnections()
+	}()
+	for i := 0; i < s.consumers(); i++ {
+		go func() {
+			defer s.wg.Done()
+			if err := s.consume(consumerCtx, cc, id, status.CommitIndex+1, expected); err != nil {
+				s.mutex.Lock()
+				s.errs = append(s.errs, err)
+				s.mutex.Unlock()
+			}
+		}()
+	}
+	return nil
+}
+
+// consume follows the log starting at first until it has seen expected
+// test entries.
+func (s *ReplicatedLogConsumerTest) consume(ctx context.Context, c *Context, id uint, first uint64, expected int) error {
+	samples := make([]time.Duration, 0, expected)
+	defer func() {
+		s.mutex.Lock()
+		s.samples = append(s.samples, samples...)
+		s.mutex.Unlock()
+	}()
+
+	for len(samples) < expected {
+		entries, err := c.pollReplicatedLog(ctx, id, first, consumerPollLimit)
+		if err != nil {
+			return fmt.Errorf("failed to poll log after %d of %d entries: %w", len(samples), expected, err)
+		}
+		received := time.Now()
+		for _, e := range entries {
+			if entry, ok := decodeTimestampedEntry(e.Payload); ok {
+				samples = append(samples, received.Sub(time.Unix(0, entry.Timestamp)))
+			}
+			if e.LogIndex >= first {
+				first = e.LogIndex + 1
+			}
+		}
+	}
+	return nil
+}
+
+// decodeTimestampedEntry returns the entry of a payload, which is false for
+// entries not written by the test such as those of a new term.
+func decodeTimestampedEntry(payload json.RawMessage) (TimestampedLogEntry, bool) {
+	var entry TimestampedLogEntry
+	if err := json.Unmarshal(payload, &entry); err == nil {
+		return entry, entry.Timestamp != 0
+	}
+	// some versions wrap the payload into an array
+	var wrapped []TimestampedLogEntry
+	if err := json.Unmarshal(payload, &wrapped); err == nil && len(wrapped) == 1 {
+		return wrapped[0], wrapped[0].Timestamp != 0
+	}
+	return entry, false
+}
+
+func (s *ReplicatedLogConsumerTest) QuorumResults(ctx context.Context) (QuorumResults, error) {
+	return s.quorums.results(), nil
+}
+
+func (s *ReplicatedLogConsumerTest) Latencies(ctx context.Context) (map[string]LatencyDistribution, error) {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(consumerDrainTimeout):
+		s.cancel()
+		<-done
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.errs) > 0 {
+		return nil, fmt.Errorf("consumer failed: %w", s.errs[0])
+	}
+	return map[string]LatencyDistribution{"visibility": calcDistribution(s.samples)}, nil
+}
+
+func (s *ReplicatedLogConsumerTest) TearDownTest(ctx context.Context, c *Context, id uint) error {
+	s.cancel()
+	s.wg.Wait()
+	return c.dropReplicatedLog(ctx, id)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReplicatedLogFailoverTest inserts entries while the leader of the log is
+// changed every Interval.
+type ReplicatedLogFailoverTest struct {
+	replicatedLogMarker
+	backgroundDisruptor
+
+	Interval time.Duration
+}
+
+func (s *ReplicatedLogFailoverTest) GetTestName(test TestSettings) string {
+	return disruptionTestName("failover", test, s.Interval, "")
+}
+
+func (s *ReplicatedLogFailoverTest) SetupTest(ctx context.Context, c *Context, id uint, test TestSettings) error {
+	return s.setUp(ctx, c, id, test, s.Interval, func(ctx context.Context) error {
+		return s.changeLeader(ctx, s.control, id)
+	})
+}
+
+// changeLeader makes another participant the leader.
+func (s *ReplicatedLogFailoverTest) changeLeader(ctx context.Context, c *Context, id uint) error {
+	status, err := c.GetReplicatedLogStatus(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get log status for failover: %w", err)
+	}
+	var next string
+	for _, name := range status.participantNames() {
+		if name != status.Leader {
+			next = name
+			break
+		}
+	}
+	if next == "" {
+		return fmt.Errorf("log %d has no participant to take over from leader %q", id, status.Leader)
+	}
+
+	s.recorder.event(time.Now())
+	if err := c.setReplicatedLogLeader(ctx, id, next); err != nil {
+		return fmt.Errorf("failed to change leader from %s to %s: %w", status.Leader, next, err)
+	}
+	return nil
+}
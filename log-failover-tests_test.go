@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// participantsStatusResponse is the status of a log led by leader with the
+// given participants.
+func participantsStatusResponse(leader string, participants ...string) interface{} {
+	config := make(map[string]interface{})
+	for _, p := range participants {
+		config[p] = map[string]interface{}{"allowedInQuorum": true, "allowedAsLeader": true}
+	}
+	return map[string]interface{}{"result": map[string]interface{}{
+		"leaderId": leader,
+		"specification": map[string]interface{}{"plan": map[string]interface{}{
+			"currentTerm":        map[string]interface{}{"term": 2},
+			"participantsConfig": map[string]interface{}{"generation": 1, "participants": config},
+		}},
+	}}
+}
+
+func TestFailoverChangesToAnotherParticipant(t *testing.T) {
+	r := &cassetteRecorder{t: t}
+	r.add("GET", "/_api/log/3", nil, 200, participantsStatusResponse("PRMR-1", "PRMR-1", "PRMR-2", "PRMR-3"))
+	r.add("POST", "/_api/log/3/leader/PRMR-2", nil, 200, map[string]interface{}{})
+	r.add("GET", "/_api/log/3", nil, 200, participantsStatusResponse("PRMR-2", "PRMR-1", "PRMR-2", "PRMR-3"))
+	r.add("POST", "/_api/log/3/leader/PRMR-1", nil, 200, map[string]interface{}{})
+	r.add("GET", "/_api/log/3", nil, 200, participantsStatusResponse("PRMR-1", "PRMR-1"))
+
+	c, cassette := r.replay(ContextOptions{})
+	impl := &ReplicatedLogFailoverTest{}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := impl.changeLeader(ctx, c, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := impl.changeLeader(ctx, c, 3); err == nil || !strings.Contains(err.Error(), "no participant to take over") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if events := impl.recorder.results(time.Second).Events; events != 2 {
+		t.Errorf("%v failovers, expected 2", events)
+	}
+	if unplayed := cassette.unplayed(); len(unplayed) > 0 {
+		t.Errorf("requests were not sent: %v", unplayed)
+	}
+}
+
+func TestFailoverFailedInsertsHaveNoLatency(t *testing.T) {
+	test := TestSettings{NumberOfRequests: 3, NumberOfThreads: 1, Config: Config{WriteConcern: 1}}
+	r := &cassetteRecorder{t: t}
+	r.add("POST", "/_api/log/3/insert", LogEntry{0, 0}, 201, insertResponse(1, "PRMR-1"))
+	r.add("POST", "/_api/log/3/insert", LogEntry{0, 1}, 503, map[string]interface{}{
+		"error": true, "code": 503, "errorNum": ErrorClusterNotLeader, "errorMessage": "not leader",
+	})
+	r.add("POST", "/_api/log/3/insert", LogEntry{0, 2}, 201, insertResponse(2, "PRMR-1"))
+
+	c, _ := r.replay(ContextOptions{})
+	impl := &ReplicatedLogFailoverTest{}
+	impl.recorder.reset(test.NumberOfThreads, test.NumberOfRequests)
+	impl.quorums.reset()
+	results, err := runThreads(context.Background(), c, impl, 3, test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0] <= 0 || results[1] != 0 || results[2] <= 0 {
+		t.Errorf("unexpected latencies %v", results)
+	}
+	if failed := impl.recorder.results(time.Second).FailedRequests; failed != 1 {
+		t.Errorf("%v failed requests, expected 1", failed)
+	}
+	if quorum, _ := impl.QuorumResults(context.Background()); quorum.Committed != 2 {
+		t.Errorf("%v committed inserts, expected 2", quorum.Committed)
+	}
+	if rps := calcResults(time.Second, results).RequsterPerSecond; rps != 2 {
+		t.Errorf("%v requests per second, expected 2", rps)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type LogReadOperation string
+
+const (
+	ReadHead  LogReadOperation = "head"
+	ReadTail  LogReadOperation = "tail"
+	ReadSlice LogReadOperation = "slice"
+	ReadEntry LogReadOperation = "entry"
+)
+
+const (
+	defaultSliceSize   = 10
+	defaultPrefill     = 10000
+	prefillParallelism = 16
+)
+
+// ReplicatedLogReadTest fills a log and then measures reads of it.
+type ReplicatedLogReadTest struct {
+	replicatedLogMarker
+
+	Operation LogReadOperation
+
+	// the committed index range written by the setup
+	firstIndex, lastIndex uint64
+}
+
+func sliceSize(test TestSettings) uint {
+	if test.Config.SliceSize == 0 {
+		return defaultSliceSize
+	}
+	return test.Config.SliceSize
+}
+
+func (s *ReplicatedLogReadTest) RunTestThread(ctx context.Context, c *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error {
+	size := sliceSize(test)
+	rnd := rand.New(rand.NewSource(int64(threadNo)))
+	randomIndex := func(last uint64) uint64 {
+		if last <= s.firstIndex {
+			return s.firstIndex
+		}
+		return s.firstIndex + uint64(rnd.Int63n(int64(last-s.firstIndex+1)))
+	}
+
+	for k := 0; k < test.NumberOfRequests; k++ {
+		if err := c.beforeRequest(ctx); err != nil {
+			return err
+		}
+		req_start := time.Now()
+		var err error
+		switch s.Operation {
+		case ReadHead:
+			err = c.headReplicatedLog(ctx, id, size, nil)
+		case ReadTail:
+			err = c.tailReplicatedLog(ctx, id, size, nil)
+		case ReadSlice:
+			last := s.lastIndex
+			if last >= s.firstIndex+uint64(size) {
+				last -= uint64(size) - 1
+			}
+			start := randomIndex(last)
+			err = c.sliceReplicatedLog(ctx, id, start, start+uint64(size), nil)
+		case ReadEntry:
+			err = c.readReplicatedLogEntry(ctx, id, randomIndex(s.lastIndex), nil)
+		default:
+			err = fmt.Errorf("unknown read operation %q", s.Operation)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s of log during test: %w", s.Operation, err)
+		}
+		results[k] = time.Since(req_start)
+	}
+
+	return nil
+}
+
+func (s *ReplicatedLogReadTest) GetTestName(test TestSettings) string {
+	name := fmt.Sprintf("read-%s-c%d-r%d", s.Operation, test.NumberOfThreads, test.NumberOfServers)
+	if s.Operation != ReadEntry {
+		name = name + fmt.Sprintf("-s%d", sliceSize(test))
+	}
+	return name
+}
+
+func (s *ReplicatedLogReadTest) SetupTest(ctx context.Context, c *Context, id uint, test TestSettings) error {
+	if err := c.createReplicatedLog(ctx, id, test.Config.logConfig()); err != nil {
+		return err
+	}
+
+	if err := s.fill(ctx, c, id, test); err != nil {
+		c.dropReplicatedLog(context.Background(), id)
+		return err
+	}
+
+	return nil
+}
+
+func (s *ReplicatedLogReadTest) fill(ctx context.Context, c *Context, id uint, test TestSettings) error {
+	if err := c.waitForReplicatedLog(ctx, id); err != nil {
+		return err
+	}
+
+	prefill := int(test.Config.Prefill)
+	if prefill == 0 {
+		prefill = defaultPrefill
+	}
+	var wg sync.WaitGroup
+	errch := make(chan error, prefillParallelism)
+	for i := 0; i < prefillParallelism; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := i; k < prefill; k += prefillParallelism {
+				if _, err := c.insertReplicatedLog(ctx, id, LogEntry{-1, k}); err != nil {
+					errch <- fmt.Errorf("failed to fill log: %w", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errch)
+	if err := <-errch; err != nil {
+		return err
+	}
+
+	status, err := c.GetReplicatedLogStatus(ctx, id)
+	if err != nil {
+		return err
+	}
+	s.firstIndex, s.lastIndex = 1, status.CommitIndex
+	if leader, ok := status.Participants[status.Leader]; ok && leader.FirstIndex > 0 {
+		s.firstIndex = leader.FirstIndex
+	}
+	return nil
+}
+
+func (s *ReplicatedLogReadTest) TearDownTest(ctx context.Context, c *Context, id uint) error {
+	return c.dropReplicatedLog(ctx, id)
+}
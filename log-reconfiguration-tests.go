@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// reconfigurationTimeout bounds the time a reconfiguration may take to be
+// applied and the log to commit again afterwards.
+const reconfigurationTimeout = time.Minute
+
+// ReplicatedLogReconfigurationTest inserts entries while it alternately
+// replaces a follower with a spare database server and changes the write
+// concern of the log, every Interval. After each reconfiguration the log has
+// to commit new entries.
+type ReplicatedLogReconfigurationTest struct {
+	replicatedLogMarker
+	backgroundDisruptor
+
+	Interval time.Duration
+
+	// time to apply of every kind of reconfiguration
+	applyTimes map[string][]time.Duration
+}
+
+func (s *ReplicatedLogReconfigurationTest) GetTestName(test TestSettings) string {
+	return disruptionTestName("reconfigure", test, s.Interval, "")
+}
+
+// SetupTest fails unless the cluster has a spare database server besides
+// the participants of the log, which is required to replace a follower.
+func (s *ReplicatedLogReconfigurationTest) SetupTest(ctx context.Context, c *Context, id uint, test TestSettings) error {
+	servers, err := c.getHealthyDBServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database servers: %w", err)
+	}
+	if uint(len(servers)) <= test.NumberOfServers {
+		return fmt.Errorf("replacing a follower requires a spare database server, but the cluster has %d healthy database servers for %d participants",
+			len(servers), test.NumberOfServers)
+	}
+
+	s.applyTimes = make(map[string][]time.Duration)
+	configs := []Config{alternativeConfig(test), test.Config.logConfig()}
+	round := 0
+	return s.setUp(ctx, c, id, test, s.Interval, func(ctx context.Context) error {
+		// replacing a follower and changing the write concern alternate
+		defer func() { round++ }()
+		if round%2 == 0 {
+			return s.replaceFollower(ctx, s.control, id)
+		}
+		return s.changeConfig(ctx, s.control, id, configs[(round/2)%2])
+	})
+}
+
+// alternativeConfig returns the config the write concern is switched to
+// from the one of the test.
+func alternativeConfig(test TestSettings) Config {
+	config := test.Config.logConfig()
+	if config.WriteConcern > 1 {
+		config.WriteConcern, config.SoftWriteConcern = 1, 1
+	} else {
+		config.WriteConcern, config.SoftWriteConcern = 2, test.NumberOfServers
+	}
+	return config
+}
+
+// replaceFollower replaces a follower with a database server that does not
+// participate in the log.
+func (s *ReplicatedLogReconfigurationTest) replaceFollower(ctx context.Context, c *Context, id uint) error {
+	status, err := c.GetReplicatedLogStatus(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get log status: %w", err)
+	}
+	servers, err := c.getHealthyDBServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database servers: %w", err)
+	}
+
+	var old, replacement string
+	for _, name := range status.participantNames() {
+		if name != status.Leader {
+			old = name
+			break
+		}
+	}
+	for _, server := range servers {
+		if _, ok := status.Configuration.Participants[server]; !ok {
+			replacement = server
+			break
+		}
+	}
+	if old == "" {
+		return fmt.Errorf("log %d has no follower besides leader %q", id, status.Leader)
+	}
+	if replacement == "" {
+		return fmt.Errorf("no spare database server to replace follower %s of log %d, healthy servers are %v", old, id, servers)
+	}
+
+	return s.apply(ctx, c, id, "replace", func() error {
+		return c.replaceReplicatedLogParticipant(ctx, id, old, replacement)
+	}, func(current *ReplicatedLogStatus) string {
+		if _, ok := current.Configuration.Participants[old]; ok {
+			return fmt.Sprintf("%s is still a participant", old)
+		}
+		if _, ok := current.Configuration.Participants[replacement]; !ok {
+			return fmt.Sprintf("%s is not yet a participant", replacement)
+		}
+		return ""
+	})
+}
+
+func (s *ReplicatedLogReconfigurationTest) changeConfig(ctx context.Context, c *Context, id uint, config Config) error {
+	return s.apply(ctx, c, id, "writeConcern", func() error {
+		return c.updateReplicatedLogConfig(ctx, id, config)
+	}, func(status *ReplicatedLogStatus) string {
+		current := status.Configuration.Config
+		if current.WriteConcern != config.WriteConcern || current.SoftWriteConcern != config.SoftWriteConcern {
+			return fmt.Sprintf("write concern is %d/%d, expected %d/%d",
+				current.WriteConcern, current.SoftWriteConcern, config.WriteConcern, config.SoftWriteConcern)
+		}
+		return ""
+	})
+}
+
+// apply runs the reconfiguration, waits until check reports it as applied
+// and the log committed new entries, and records the time it took.
+func (s *ReplicatedLogReconfigurationTest) apply(ctx context.Context, c *Context, id uint, kind string,
+	reconfigure func() error, check func(*ReplicatedLogStatus) string) error {
+	start := time.Now()
+	s.recorder.event(start)
+	if err := reconfigure(); err != nil {
+		return fmt.Errorf("%s reconfiguration failed: %w", kind, err)
+	}
+
+	// the commit index is taken from the status that showed the
+	// reconfiguration, so no further request can fail in between
+	var commitIndex uint64
+	applied := ReadinessCondition{Name: kind, Timeout: reconfigurationTimeout, check: func(status *ReplicatedLogStatus) string {
+		commitIndex = status.CommitIndex
+		return check(status)
+	}}
+	if err := c.waitForCondition(ctx, id, applied); err != nil {
+		return err
+	}
+	s.applyTimes[kind] = append(s.applyTimes[kind], time.Since(start))
+
+	if err := c.waitForCondition(ctx, id, CommitIndexReached(commitIndex+1, reconfigurationTimeout)); err != nil {
+		return fmt.Errorf("log stopped committing after %s reconfiguration: %w", kind, err)
+	}
+	return nil
+}
+
+// Latencies reports the time to apply of every kind of reconfiguration.
+func (s *ReplicatedLogReconfigurationTest) Latencies(ctx context.Context) (map[string]LatencyDistribution, error) {
+	s.stop()
+	result := make(map[string]LatencyDistribution)
+	for kind, samples := range s.applyTimes {
+		result["apply-"+kind] = calcDistribution(samples)
+	}
+	return result, nil
+}
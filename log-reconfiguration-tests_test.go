@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func healthResponse(servers ...string) interface{} {
+	health := make(map[string]interface{})
+	for _, s := range servers {
+		health[s] = map[string]interface{}{"Role": "DBServer", "Status": "GOOD"}
+	}
+	return map[string]interface{}{"Health": health}
+}
+
+// committedStatusResponse is the status of a log whose leader committed
+// up to commitIndex.
+func committedStatusResponse(leader string, commitIndex uint64, participants ...string) interface{} {
+	response := participantsStatusResponse(leader, participants...)
+	response.(map[string]interface{})["result"].(map[string]interface{})["participants"] = map[string]interface{}{
+		leader: map[string]interface{}{"response": map[string]interface{}{
+			"role": "leader", "term": 2, "local": map[string]interface{}{"commitIndex": commitIndex},
+		}},
+	}
+	return response
+}
+
+// configuredStatusResponse is the status of a log with the given config,
+// whose leader PRMR-1 committed up to commitIndex.
+func configuredStatusResponse(config Config, commitIndex uint64, participants ...string) interface{} {
+	response := committedStatusResponse("PRMR-1", commitIndex, participants...)
+	plan := response.(map[string]interface{})["result"].(map[string]interface{})["specification"].(map[string]interface{})["plan"].(map[string]interface{})
+	plan["currentTerm"] = map[string]interface{}{"term": 2, "config": config}
+	return response
+}
+
+func TestReconfigurationRequiresSpareServer(t *testing.T) {
+	test := TestSettings{NumberOfThreads: 1, NumberOfServers: 3, Config: Config{WriteConcern: 2}}
+	r := &cassetteRecorder{t: t}
+	r.add("GET", "/_admin/cluster/health", nil, 200, healthResponse("PRMR-1", "PRMR-2", "PRMR-3"))
+
+	c, _ := r.replay(ContextOptions{})
+	err := (&ReplicatedLogReconfigurationTest{}).SetupTest(context.Background(), c, 3, test)
+	if err == nil || !strings.Contains(err.Error(), "requires a spare database server") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestReconfigurationAlternatesReplacementAndConfig(t *testing.T) {
+	const id = 3
+	test := TestSettings{NumberOfThreads: 1, NumberOfServers: 3, Config: Config{WriteConcern: 2, SoftWriteConcern: 2}}
+	alternative := Config{WriteConcern: 1, SoftWriteConcern: 1}
+	r := &cassetteRecorder{t: t}
+	r.add("GET", "/_admin/cluster/health", nil, 200, healthResponse("PRMR-1", "PRMR-2", "PRMR-3", "PRMR-4"))
+	r.add("POST", "/_api/log", nil, 200, map[string]interface{}{})
+	r.add("GET", "/_api/log/3", nil, 200, participantsStatusResponse("PRMR-1", "PRMR-1", "PRMR-2", "PRMR-3"))
+	// the first tick replaces a follower
+	r.add("GET", "/_api/log/3", nil, 200, participantsStatusResponse("PRMR-1", "PRMR-1", "PRMR-2", "PRMR-3"))
+	r.add("GET", "/_admin/cluster/health", nil, 200, healthResponse("PRMR-1", "PRMR-2", "PRMR-3", "PRMR-4"))
+	r.add("POST", "/_api/log/3/participant/PRMR-2/replace-with/PRMR-4", nil, 200, map[string]interface{}{})
+	r.add("GET", "/_api/log/3", nil, 200, committedStatusResponse("PRMR-1", 5, "PRMR-1", "PRMR-3", "PRMR-4"))
+	r.add("GET", "/_api/log/3", nil, 200, committedStatusResponse("PRMR-1", 6, "PRMR-1", "PRMR-3", "PRMR-4"))
+	// the second one switches to the alternative write concern, the
+	// status only shows it as applied if the right config was sent
+	r.add("POST", "/_api/log/3/config", alternative, 200, map[string]interface{}{})
+	r.add("GET", "/_api/log/3", nil, 200, configuredStatusResponse(alternative, 6, "PRMR-1", "PRMR-3", "PRMR-4"))
+	r.add("GET", "/_api/log/3", nil, 200, configuredStatusResponse(alternative, 7, "PRMR-1", "PRMR-3", "PRMR-4"))
+	// the third tick replaces a follower again and fails since nothing is
+	// left to replay
+
+	c, cassette := r.replay(ContextOptions{})
+	impl := &ReplicatedLogReconfigurationTest{Interval: 10 * time.Millisecond}
+	if err := impl.SetupTest(context.Background(), c, id, test); err != nil {
+		t.Fatal(err)
+	}
+	impl.wg.Wait()
+
+	_, err := impl.DisruptionResults(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "no recorded response left for GET /_api/log/3") {
+		t.Errorf("unexpected error %v", err)
+	}
+	latencies, _ := impl.Latencies(context.Background())
+	if len(latencies) != 2 || latencies["apply-replace"].Count != 1 || latencies["apply-writeConcern"].Count != 1 {
+		t.Errorf("unexpected latencies %+v", latencies)
+	}
+	if events := impl.recorder.results(time.Second).Events; events != 2 {
+		t.Errorf("%v reconfigurations, expected 2", events)
+	}
+	if unplayed := cassette.unplayed(); len(unplayed) > 0 {
+		t.Errorf("requests were not sent: %v", unplayed)
+	}
+}
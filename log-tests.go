@@ -1,51 +1,136 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
 
-type ReplicatedLogsTest struct{}
+// ReplicatedLogsTest inserts entries into a log. Every thread checks that
+// the indexes assigned to its entries are strictly increasing.
+type ReplicatedLogsTest struct {
+	replicatedLogMarker
+
+	quorums quorumRecorder
+}
 
 type LogEntry struct {
 	Client int `json:"client"`
 	Index  int `json:"index"`
 }
 
-func (ReplicatedLogsTest) RunTestThread(ctx *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error {
+func (s *ReplicatedLogsTest) RunTestThread(ctx context.Context, c *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error {
+	if test.Config.BatchSize > 1 {
+		return s.runBatchInsertThread(ctx, c, id, test, threadNo, results)
+	}
+
+	inserts := insertChecker{quorums: &s.quorums}
 	for k := 0; k < test.NumberOfRequests; k++ {
 		entry := LogEntry{threadNo, k}
+		if err := c.beforeRequest(ctx); err != nil {
+			return err
+		}
 		req_start := time.Now()
-		if err := ctx.insertReplicatedLog(id, entry); err != nil {
-			return fmt.Errorf("failed to insert log entry during test: %v", err)
+		res, err := c.insertReplicatedLog(ctx, id, entry)
+		if err != nil {
+			return fmt.Errorf("failed to insert log entry during test: %w", err)
 		}
 		results[k] = time.Since(req_start)
+		if err := inserts.check(res); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (ReplicatedLogsTest) GetTestName(test TestSettings) string {
+func (s *ReplicatedLogsTest) runBatchInsertThread(ctx context.Context, c *Context, id uint, test TestSettings, threadNo int, results []time.Duration) error {
+	inserts := insertChecker{quorums: &s.quorums}
+	entries := make([]LogEntry, test.Config.BatchSize)
+	for k := 0; k < test.NumberOfRequests; k++ {
+		for j := range entries {
+			entries[j] = LogEntry{threadNo, k*len(entries) + j}
+		}
+		if err := c.beforeRequest(ctx); err != nil {
+			return err
+		}
+		req_start := time.Now()
+		res, err := c.multiInsertReplicatedLog(ctx, id, entries)
+		if err != nil {
+			return fmt.Errorf("failed to insert log entries during test: %w", err)
+		}
+		results[k] = time.Since(req_start)
+		if err := inserts.check(res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertChecker is used by every thread that inserts into a log. It checks
+// that the inserts of the thread are assigned strictly increasing indexes
+// and records the quorums that committed them.
+type insertChecker struct {
+	quorums *quorumRecorder
+	last    uint64
+}
+
+func (i *insertChecker) check(res *ReplicatedLogInsertResult) error {
+	if res.Index <= i.last {
+		return fmt.Errorf("insert was assigned index %d after a previous insert of the same thread got %d", res.Index, i.last)
+	}
+	i.last = res.Index
+	i.quorums.record(res.Quorum)
+	return nil
+}
+
+func (*ReplicatedLogsTest) EntriesPerRequest(test TestSettings) int {
+	if test.Config.BatchSize > 1 {
+		return int(test.Config.BatchSize)
+	}
+	return 1
+}
+
+func (s *ReplicatedLogsTest) QuorumResults(ctx context.Context) (QuorumResults, error) {
+	return s.quorums.results(), nil
+}
+
+// logConfig returns the part of the config that belongs to the log
+// configuration, the remaining fields only control the test.
+func (config Config) logConfig() Config {
+	return Config{
+		WriteConcern:     config.WriteConcern,
+		SoftWriteConcern: config.SoftWriteConcern,
+		WaitForSync:      config.WaitForSync,
+	}
+}
+
+func (*ReplicatedLogsTest) GetTestName(test TestSettings) string {
 	name := fmt.Sprintf("insert-c%d-r%d-wc%d", test.NumberOfThreads, test.NumberOfServers, test.Config.WriteConcern)
+	if test.Config.BatchSize > 1 {
+		name = name + fmt.Sprintf("-b%d", test.Config.BatchSize)
+	}
 	if test.Config.WaitForSync {
 		name = name + "-ws"
 	}
 	return name
 }
 
-func (ReplicatedLogsTest) SetupTest(ctx *Context, id uint, test TestSettings) error {
-	if err := ctx.createReplicatedLog(id, test.Config); err != nil {
+func (s *ReplicatedLogsTest) SetupTest(ctx context.Context, c *Context, id uint, test TestSettings) error {
+	s.quorums.reset()
+	if err := c.createReplicatedLog(ctx, id, test.Config.logConfig()); err != nil {
 		return err
 	}
 
-	if err := ctx.waitForReplicatedLog(id); err != nil {
-		ctx.dropReplicatedLog(id)
+	if err := c.waitForReplicatedLog(ctx, id); err != nil {
+		c.dropReplicatedLog(context.Background(), id)
 		return err
 	}
 
 	return nil
 }
 
-func (ReplicatedLogsTest) TearDownTest(ctx *Context, id uint) error {
-	return ctx.dropReplicatedLog(id)
+func (*ReplicatedLogsTest) TearDownTest(ctx context.Context, c *Context, id uint) error {
+	return c.dropReplicatedLog(ctx, id)
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runThreads runs all threads of a test like runTestImpl does.
+func runThreads(ctx context.Context, c *Context, impl TestImplementation, id uint, test TestSettings) ([]time.Duration, error) {
+	results := make([]time.Duration, test.NumberOfRequests*test.NumberOfThreads)
+	errs := make(chan error, test.NumberOfThreads)
+	var wg sync.WaitGroup
+	for i := 0; i < test.NumberOfThreads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slice := results[i*test.NumberOfRequests : (i+1)*test.NumberOfRequests]
+			if err := impl.RunTestThread(ctx, c.forWorker(i), id, test, i, slice); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	return results, <-errs
+}
+
+func logStatusResponse(leader string) interface{} {
+	return map[string]interface{}{"result": map[string]interface{}{
+		"leaderId": leader,
+		"specification": map[string]interface{}{"plan": map[string]interface{}{
+			"currentTerm": map[string]interface{}{"term": 2},
+		}},
+	}}
+}
+
+func insertResponse(index uint64, quorum ...string) interface{} {
+	return map[string]interface{}{"result": map[string]interface{}{
+		"index": index,
+		"result": map[string]interface{}{
+			"commitIndex": index,
+			"quorum":      map[string]interface{}{"term": 2, "quorum": quorum},
+		},
+	}}
+}
+
+func TestReplicatedLogsTestIndexOrder(t *testing.T) {
+	test := TestSettings{NumberOfRequests: 2, NumberOfThreads: 1, Config: Config{WriteConcern: 1}}
+	r := &cassetteRecorder{t: t}
+	r.add("POST", "/_api/log/3/insert", LogEntry{0, 0}, 201, insertResponse(10))
+	r.add("POST", "/_api/log/3/insert", LogEntry{0, 1}, 201, insertResponse(9))
+
+	c, _ := r.replay(ContextOptions{})
+	_, err := runThreads(context.Background(), c, &ReplicatedLogsTest{}, 3, test)
+	if err == nil || !strings.Contains(err.Error(), "index 9") {
+		t.Errorf("decreasing index was not detected: %v", err)
+	}
+}
+
+func TestEntriesPerRequest(t *testing.T) {
+	batched := TestSettings{Config: Config{BatchSize: 64}}
+	if n := (&ReplicatedLogsTest{}).EntriesPerRequest(batched); n != 64 {
+		t.Errorf("%d entries per batch request, expected 64", n)
+	}
+	if n := (&ReplicatedLogsTest{}).EntriesPerRequest(TestSettings{}); n != 1 {
+		t.Errorf("%d entries per request, expected 1", n)
+	}
+	// tests that never batch ignore the batch size of their settings
+	for _, impl := range []TestImplementation{
+		&ReplicatedLogReadTest{}, &ReplicatedLogConsumerTest{}, &ReplicatedLogFailoverTest{},
+		&ReplicatedLogReconfigurationTest{}, &ReplicatedLogCompactionTest{},
+	} {
+		if _, ok := impl.(BatchingTest); ok {
+			t.Errorf("%T reports batches", impl)
+		}
+	}
+}
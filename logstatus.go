@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+type LogIndexTerm struct {
+	Term  uint64 `json:"term"`
+	Index uint64 `json:"index"`
+}
+
+// ParticipantStatus is the state of a single participant of a replicated
+// log as reported by the participant itself.
+type ParticipantStatus struct {
+	// ErrorCode and ErrorMessage describe why the coordinator could not
+	// reach the participant, the remaining fields are empty in that case.
+	ErrorCode    int    `json:"errorCode,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+
+	Role                  string       `json:"role"`
+	Term                  uint64       `json:"term"`
+	CommitIndex           uint64       `json:"commitIndex"`
+	Spearhead             LogIndexTerm `json:"spearhead"`
+	FirstIndex            uint64       `json:"firstIndex"`
+	ReleaseIndex          uint64       `json:"releaseIndex"`
+	State                 string       `json:"state,omitempty"`
+	LeadershipEstablished bool         `json:"leadershipEstablished,omitempty"`
+}
+
+type ParticipantFlags struct {
+	AllowedInQuorum bool `json:"allowedInQuorum"`
+	AllowedAsLeader bool `json:"allowedAsLeader"`
+	Forced          bool `json:"forced"`
+}
+
+// ReplicatedLogConfiguration is the configuration of the current term.
+type ReplicatedLogConfiguration struct {
+	Config       Config                      `json:"config"`
+	Generation   uint64                      `json:"generation"`
+	Participants map[string]ParticipantFlags `json:"participants"`
+}
+
+type ReplicatedLogStatus struct {
+	Term   uint64 `json:"term"`
+	Leader string `json:"leader,omitempty"`
+	// CommitIndex and Spearhead are the ones of the leader.
+	CommitIndex   uint64                       `json:"commitIndex"`
+	Spearhead     LogIndexTerm                 `json:"spearhead"`
+	Participants  map[string]ParticipantStatus `json:"participants"`
+	Configuration ReplicatedLogConfiguration   `json:"configuration"`
+}
+
+// replicatedLogStatusResponse is the body of GET _api/log/<id> on a
+// coordinator.
+type replicatedLogStatusResponse struct {
+	Result struct {
+		LeaderId      string `json:"leaderId"`
+		Specification struct {
+			Plan struct {
+				CurrentTerm struct {
+					Term   uint64 `json:"term"`
+					Config Config `json:"config"`
+				} `json:"currentTerm"`
+				ParticipantsConfig struct {
+					Generation   uint64                      `json:"generation"`
+					Participants map[string]ParticipantFlags `json:"participants"`
+				} `json:"participantsConfig"`
+			} `json:"plan"`
+		} `json:"specification"`
+		Participants map[string]struct {
+			Connection struct {
+				ErrorCode    int    `json:"errorCode"`
+				ErrorMessage string `json:"errorMessage"`
+			} `json:"connection"`
+			Response struct {
+				Role                  string `json:"role"`
+				Term                  uint64 `json:"term"`
+				LeadershipEstablished bool   `json:"leadershipEstablished"`
+				Local                 struct {
+					CommitIndex  uint64       `json:"commitIndex"`
+					Spearhead    LogIndexTerm `json:"spearhead"`
+					FirstIndex   uint64       `json:"firstIndex"`
+					ReleaseIndex uint64       `json:"releaseIndex"`
+					State        string       `json:"state"`
+				} `json:"local"`
+			} `json:"response"`
+		} `json:"participants"`
+	} `json:"result"`
+}
+
+func (c *Context) GetReplicatedLogStatus(ctx context.Context, id uint) (*ReplicatedLogStatus, error) {
+	var response replicatedLogStatusResponse
+	if err := c.request(ctx, "GET", fmt.Sprintf("_api/log/%d", id), nil, &response, 200); err != nil {
+		return nil, err
+	}
+
+	r := &response.Result
+	plan := &r.Specification.Plan
+	status := &ReplicatedLogStatus{
+		Term:         plan.CurrentTerm.Term,
+		Leader:       r.LeaderId,
+		Participants: make(map[string]ParticipantStatus, len(r.Participants)),
+		Configuration: ReplicatedLogConfiguration{
+			Config:       plan.CurrentTerm.Config,
+			Generation:   plan.ParticipantsConfig.Generation,
+			Participants: plan.ParticipantsConfig.Participants,
+		},
+	}
+	for name, p := range r.Participants {
+		status.Participants[name] = ParticipantStatus{
+			ErrorCode:             p.Connection.ErrorCode,
+			ErrorMessage:          p.Connection.ErrorMessage,
+			Role:                  p.Response.Role,
+			Term:                  p.Response.Term,
+			CommitIndex:           p.Response.Local.CommitIndex,
+			Spearhead:             p.Response.Local.Spearhead,
+			FirstIndex:            p.Response.Local.FirstIndex,
+			ReleaseIndex:          p.Response.Local.ReleaseIndex,
+			State:                 p.Response.Local.State,
+			LeadershipEstablished: p.Response.LeadershipEstablished,
+		}
+	}
+	if leader, ok := status.Participants[status.Leader]; ok {
+		status.CommitIndex, status.Spearhead = leader.CommitIndex, leader.Spearhead
+		if leader.Term > status.Term {
+			status.Term = leader.Term
+		}
+	}
+	return status, nil
+}
+
+// ReplicatedLogResult is the state of the log of a test after its last run.
+type ReplicatedLogResult struct {
+	CommitIndex uint64 `json:"commitIndex"`
+	Term        uint64 `json:"term"`
+}
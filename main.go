@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,23 +22,52 @@ type Config struct {
 	ReplicationVersion string `json:"replicationVersion,omitempty"`
 	DocumentSize       uint   `json:"documentSize,omitempty"`
 	BatchSize          uint   `json:"batchSize,omitempty"`
+	// SliceSize is the number of entries read per request, Prefill the
+	// number of entries written before a read test starts.
+	SliceSize uint `json:"sliceSize,omitempty"`
+	Prefill   uint `json:"prefill,omitempty"`
 }
 
 const NumberOfTestRuns = uint(1)
 
 type ResultEntry struct {
-	Name    string                       `json:"name"`
-	Test    TestSettings                 `json:"test"`
-	Result  TestResult                   `json:"result"`
-	Details [NumberOfTestRuns]TestResult `json:"details"`
+	Name     string                       `json:"name"`
+	Test     TestSettings                 `json:"test"`
+	Result   TestResult                   `json:"result"`
+	Details  [NumberOfTestRuns]TestResult `json:"details"`
+	Topology []string                     `json:"topology,omitempty"`
+	Protocol Protocol                     `json:"protocol"`
+	Encoding Encoding                     `json:"encoding"`
+	Log      *ReplicatedLogResult         `json:"log,omitempty"`
 }
 
-func (c *Context) runTestImpl(id uint, test *TestCase) (*TestResult, error) {
-	if err := test.Implementation.SetupTest(c, id, test.Settings); err != nil {
-		return nil, err
+// tearDownTimeout bounds the tear down of a test, which also runs after the
+// test itself was cancelled.
+const tearDownTimeout = time.Minute
+
+func (c *Context) runTestImpl(ctx context.Context, id uint, test *TestCase) (*TestResult, *ReplicatedLogResult, error) {
+	if test.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, test.Timeout)
+		defer cancel()
+	}
+	c = c.withRequestTimeout(test.RequestTimeout)
+	cc, err := c.withConnectionMode(test.Settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cc.Client != c.Client {
+		defer cc.Client.CloseIdleConnections()
+	}
+	c = cc
+
+	if err := test.Implementation.SetupTest(ctx, c, id, test.Settings); err != nil {
+		return nil, nil, err
 	}
 	defer func() {
-		if err := test.Implementation.TearDownTest(c, id); err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), tearDownTimeout)
+		defer cancel()
+		if err := test.Implementation.TearDownTest(ctx, c, id); err != nil {
 			fmt.Fprintf(os.Stderr, "Tear down of test %s (%d) failed: %v\n", test.Implementation.GetTestName(test.Settings), id, err)
 		}
 	}()
@@ -43,16 +76,27 @@ func (c *Context) runTestImpl(id uint, test *TestCase) (*TestResult, error) {
 
 	wg := sync.WaitGroup{}
 	errch := make(chan error, test.Settings.NumberOfThreads)
+	// the first failing thread stops all others
+	threadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lc := c.withRateLimit(test.Rate, test.WorkerRate)
 
+	c.stats.reset()
+	c.endpoints.reset()
 	start := time.Now()
 	for i := 0; i < test.Settings.NumberOfThreads; i++ {
 		wg.Add(1)
 		slice := results[i*test.Settings.NumberOfRequests : (i+1)*test.Settings.NumberOfRequests]
 		go func(i int) {
 			defer wg.Done()
-			err := test.Implementation.RunTestThread(c, id, test.Settings, i, slice)
+			wc := lc.forWorker(i)
+			if wc.clientPerWorker {
+				defer wc.Client.CloseIdleConnections()
+			}
+			err := test.Implementation.RunTestThread(threadCtx, wc, id, test.Settings, i, slice)
 			if err != nil {
 				errch <- err
+				cancel()
 			}
 		}(i)
 	}
@@ -61,7 +105,7 @@ func (c *Context) runTestImpl(id uint, test *TestCase) (*TestResult, error) {
 	select {
 	case err, ok := <-errch:
 		if ok {
-			return nil, err
+			return nil, nil, err
 		}
 		break
 	default:
@@ -70,16 +114,75 @@ func (c *Context) runTestImpl(id uint, test *TestCase) (*TestResult, error) {
 
 	duration := time.Since(start)
 	calc := calcResults(duration, results)
-	return &calc, nil
+	calc.EntriesPerSecond = calc.RequsterPerSecond
+	if bt, ok := test.Implementation.(BatchingTest); ok {
+		calc.EntriesPerSecond *= float64(bt.EntriesPerRequest(test.Settings))
+	}
+	calc.BytesPerSecond = float64(atomic.LoadInt64(&c.stats.bytesReceived)) / duration.Seconds()
+	calc.TLSHandshakes = float64(atomic.LoadInt64(&c.stats.tlsHandshakes))
+	calc.TLSHandshakeTime = time.Duration(atomic.LoadInt64(&c.stats.tlsHandshakeTime)).Seconds()
+	calc.Retries = float64(atomic.LoadInt64(&c.stats.retries))
+	calc.RetryTime = time.Duration(atomic.LoadInt64(&c.stats.retryTime)).Seconds()
+	calc.Connections = float64(atomic.LoadInt64(&c.stats.connections))
+	calc.RateLimit = c.stats.rateLimitResults(lc.targetRate(test.Settings.NumberOfThreads), duration)
+	calc.Phases = c.stats.phases.results()
+	calc.Server = c.stats.server.results()
+	calc.Coordinators = c.endpoints.results()
+	if dr, ok := test.Implementation.(DisruptionReporter); ok {
+		disruption, err := dr.DisruptionResults(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		calc.Disruption = &disruption
+	}
+	if qr, ok := test.Implementation.(QuorumReporter); ok {
+		quorum, err := qr.QuorumResults(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		calc.Quorum = &quorum
+	}
+	if lr, ok := test.Implementation.(LatencyReporter); ok {
+		if calc.Latencies, err = lr.Latencies(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var log *ReplicatedLogResult
+	if _, ok := test.Implementation.(ReplicatedLogTest); ok {
+		status, err := c.GetReplicatedLogStatus(ctx, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get the log status of test %s (%d): %v\n", test.Implementation.GetTestName(test.Settings), id, err)
+		} else {
+			log = &ReplicatedLogResult{CommitIndex: status.CommitIndex, Term: status.Term}
+		}
+	}
+	return &calc, log, nil
 }
 
-func testName(test *TestCase) string {
-	return test.Implementation.GetTestName(test.Settings)
+func testName(test *TestCase, c *Context) string {
+	name := test.Implementation.GetTestName(test.Settings) + connectionModeSuffix(test.Settings)
+	if c.protocol != HTTP1 {
+		name = name + "-" + string(c.protocol)
+	}
+	if c.encoding != JSON {
+		name = name + "-" + string(c.encoding)
+	}
+	return name
 }
 
 type TestCase struct {
 	Settings       TestSettings
 	Implementation TestImplementation
+	// Timeout limits the whole test including its setup, RequestTimeout
+	// each single request. Zero means the defaults given on the command line.
+	Timeout        time.Duration
+	RequestTimeout time.Duration
+	// Rate limits the requests per second of all threads together,
+	// WorkerRate those of every single thread. Zero means the defaults
+	// given on the command line, negative values disable the limit.
+	Rate       float64
+	WorkerRate float64
 }
 
 var testCases = []TestCase{
@@ -187,6 +290,181 @@ var testCases = []TestCase{
 		},
 		Implementation: &ReplicatedLogsTest{},
 	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+				BatchSize:        64,
+			},
+		},
+		Implementation: &ReplicatedLogsTest{},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+				BatchSize:        64,
+			},
+		},
+		Implementation: &ReplicatedLogsTest{},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogConsumerTest{Consumers: 1},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogConsumerTest{Consumers: 1},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogConsumerTest{Consumers: 4},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 20000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogFailoverTest{Interval: 5 * time.Second},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 20000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogFailoverTest{Interval: 5 * time.Second},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 20000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogReconfigurationTest{Interval: 5 * time.Second},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 20000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogReconfigurationTest{Interval: 5 * time.Second},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogCompactionTest{Interval: 2 * time.Second, Release: true},
+		WorkerRate:     200,
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogCompactionTest{Interval: 2 * time.Second, Release: false},
+		WorkerRate:     200,
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogCompactionTest{Interval: 2 * time.Second, Release: true},
+		WorkerRate:     200,
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				WaitForSync:      true,
+			},
+		},
+		Implementation: &ReplicatedLogCompactionTest{Interval: 2 * time.Second, Release: false},
+		WorkerRate:     200,
+	},
 
 	// Single Document tests
 	{
@@ -481,15 +759,150 @@ var testCases = []TestCase{
 		},
 		Implementation: &DocumentTests{},
 	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				SliceSize:        10,
+			},
+		},
+		Implementation: &ReplicatedLogReadTest{Operation: ReadHead},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				SliceSize:        10,
+			},
+		},
+		Implementation: &ReplicatedLogReadTest{Operation: ReadHead},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				SliceSize:        10,
+			},
+		},
+		Implementation: &ReplicatedLogReadTest{Operation: ReadTail},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				SliceSize:        10,
+			},
+		},
+		Implementation: &ReplicatedLogReadTest{Operation: ReadTail},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				SliceSize:        10,
+			},
+		},
+		Implementation: &ReplicatedLogReadTest{Operation: ReadSlice},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				SliceSize:        10,
+			},
+		},
+		Implementation: &ReplicatedLogReadTest{Operation: ReadSlice},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+				SliceSize:        1000,
+			},
+		},
+		Implementation: &ReplicatedLogReadTest{Operation: ReadSlice},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  1,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+			},
+		},
+		Implementation: &ReplicatedLogReadTest{Operation: ReadEntry},
+	},
+	{
+		Settings: TestSettings{
+			NumberOfRequests: 10000,
+			NumberOfThreads:  10,
+			NumberOfServers:  3,
+			Config: Config{
+				WriteConcern:     2,
+				SoftWriteConcern: 2,
+			},
+		},
+		Implementation: &ReplicatedLogReadTest{Operation: ReadEntry},
+	},
 }
 
 type Arguments struct {
-	Endpoint   string
-	OutFile    *os.File
-	QuickTests bool
+	Endpoints         []string
+	LoadBalancing     LoadBalancing
+	Discover          bool
+	DiscoveryInterval time.Duration
+	OutFile           *os.File
+	QuickTests        bool
+	Auth              Authentication
+	TLS               TLSOptions
+	Retry             RetryPolicy
+	TestTimeout       time.Duration
+	RequestTimeout    time.Duration
+	Protocols         []Protocol
+	Encodings         []Encoding
+	// ConnectionMode and ConnectionPoolSize apply to tests that do not
+	// configure a connection mode themselves.
+	ConnectionMode     ConnectionMode
+	ConnectionPoolSize int
+	Rate               float64
+	WorkerRate         float64
+	Cassette           Cassette
+	Readiness          []ReadinessCondition
 }
 
-func runTestCase(args Arguments, idx int, test *TestCase, ctx *Context) error {
+// runTestCase runs all runs of a test. The sequence number seq is unique for
+// every test case executed and determines the log ids.
+func runTestCase(ctx context.Context, args Arguments, seq int, test *TestCase, c *Context) error {
 
 	actualNumberOfRuns := NumberOfTestRuns
 
@@ -497,40 +910,95 @@ func runTestCase(args Arguments, idx int, test *TestCase, ctx *Context) error {
 		test.Settings.NumberOfRequests /= 100
 		actualNumberOfRuns = 1
 	}
+	if test.Timeout == 0 {
+		test.Timeout = args.TestTimeout
+	}
+	if test.RequestTimeout == 0 {
+		test.RequestTimeout = args.RequestTimeout
+	}
+	if test.Rate == 0 {
+		test.Rate = args.Rate
+	}
+	if test.WorkerRate == 0 {
+		test.WorkerRate = args.WorkerRate
+	}
+	if test.Settings.ConnectionMode == "" {
+		test.Settings.ConnectionMode = args.ConnectionMode
+		test.Settings.ConnectionPoolSize = args.ConnectionPoolSize
+	}
 
 	var results [NumberOfTestRuns]TestResult
+	var log *ReplicatedLogResult
 	for run := uint(0); run < actualNumberOfRuns; run++ {
-		res, err := ctx.runTestImpl(550+uint(idx)*NumberOfTestRuns+run, test)
+		res, runLog, err := c.runTestImpl(ctx, 550+uint(seq)*NumberOfTestRuns+run, test)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Test %s, run %d, failed: %v\n", test.Implementation.GetTestName(test.Settings), run, err)
 			return err
 		}
 		results[run] = *res
+		log = runLog
 	}
 	result := collectMedians(results[:actualNumberOfRuns])
 	out, _ := json.Marshal(ResultEntry{
-		Name:    testName(test),
-		Test:    test.Settings,
-		Details: results,
-		Result:  result,
+		Name:     testName(test, c),
+		Test:     test.Settings,
+		Details:  results,
+		Result:   result,
+		Topology: c.endpoints.topology(),
+		Protocol: c.protocol,
+		Encoding: c.encoding,
+		Log:      log,
 	})
 	fmt.Fprintf(args.OutFile, "%s\n", out)
 	return nil
 }
 
-func runAllTests(args Arguments) error {
-	endpoint, err := url.Parse(args.Endpoint)
+func runAllTests(ctx context.Context, args Arguments) error {
+	endpoints := make([]url.URL, len(args.Endpoints))
+	for i, e := range args.Endpoints {
+		endpoint, err := url.Parse(e)
+		if err != nil {
+			return fmt.Errorf("failed to parse endpoint %s: %w", e, err)
+		}
+		endpoints[i] = *endpoint
+	}
+
+	c, err := NewContext(endpoints, ContextOptions{
+		Auth:          args.Auth,
+		TLS:           args.TLS,
+		LoadBalancing: args.LoadBalancing,
+		Retry:         args.Retry,
+		Cassette:      args.Cassette,
+		Readiness:     args.Readiness,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to parse endpoitn: %w", err)
+		return fmt.Errorf("failed to create context: %w", err)
 	}
 
-	ctx := NewContext(endpoint)
+	if args.Discover {
+		if err := c.refreshEndpoints(ctx); err != nil {
+			return err
+		}
+		if args.DiscoveryInterval > 0 {
+			defer c.startEndpointDiscovery(ctx, args.DiscoveryInterval)()
+		}
+	}
 	numErrors := 0
 
-	for idx, test := range testCases {
-		err = runTestCase(args, idx, &test, ctx)
-		if err != nil {
-			numErrors += 1
+	seq := 0
+	for _, protocol := range args.Protocols {
+		for _, encoding := range args.Encodings {
+			pc := c.withProtocol(protocol).withEncoding(encoding)
+			for _, test := range testCases {
+				err = runTestCase(ctx, args, seq, &test, pc)
+				seq += 1
+				if err != nil {
+					numErrors += 1
+				}
+				if ctx.Err() != nil {
+					return fmt.Errorf("test run aborted: %w", ctx.Err())
+				}
+			}
 		}
 	}
 
@@ -543,10 +1011,101 @@ func runAllTests(args Arguments) error {
 func parseArguments() (*Arguments, error) {
 	outFileName := flag.String("out-file", "-", "specifies the output file, '-' is stdout.")
 	quickTests := flag.Bool("quick", false, "Run quick tests")
+	username := flag.String("username", "", "username used for authentication")
+	password := flag.String("password", "", "password used for authentication")
+	useJwt := flag.Bool("jwt", false, "acquire a JWT via _open/auth instead of sending basic auth")
+	jwtSecretFile := flag.String("jwt-secret-file", "", "file containing the JWT secret, used to sign superuser tokens")
+	caFile := flag.String("ca-file", "", "PEM file with the CA certificates used to verify the server")
+	certFile := flag.String("cert-file", "", "PEM file with the client certificate")
+	keyFile := flag.String("key-file", "", "PEM file with the client certificate key")
+	serverName := flag.String("server-name", "", "server name sent via SNI and used for verification")
+	insecure := flag.Bool("insecure", false, "do not verify the server certificate")
+	discover := flag.Bool("discover", false, "discover the coordinators via _api/cluster/endpoints of the given endpoints")
+	discoveryInterval := flag.Duration("discovery-interval", time.Minute, "interval in which discovered endpoints are refreshed, 0 disables the refresh")
+	loadBalancingName := flag.String("load-balancing", string(RoundRobin), "how requests are distributed over the endpoints: round-robin, sticky, random or least-in-flight")
+	protocolNames := flag.String("protocols", string(HTTP1), "comma separated protocols every test is run with: http1, http2 (ALPN with TLS, h2c otherwise), vst")
+	encodingNames := flag.String("encodings", string(JSON), "comma separated body encodings every test is run with: json, vpack")
+	connectionModeName := flag.String("connection-mode", string(SharedConnections), "default connection management of the tests: shared (keep-alive pool), worker (one connection per thread), pool (fixed number of connections) or no-keep-alive")
+	connectionPoolSize := flag.Int("connection-pool-size", 4, "number of connections per endpoint in the pool connection mode")
+	rate := flag.Float64("rate", 0, "default limit of requests per second of all threads of a test together, 0 means unlimited")
+	workerRate := flag.Float64("worker-rate", 0, "default limit of requests per second of every thread, 0 means unlimited")
+	recordFile := flag.String("record", "", "record all requests and responses to the given cassette file")
+	replayFile := flag.String("replay", "", "answer all requests from the given cassette file instead of a cluster")
+	readyConditions := flag.String("log-ready", "leader-elected", "comma separated conditions a replicated log has to satisfy before a test starts: leader-elected, leader-established, in-sync, commit-index=N")
+	readyTimeout := flag.Duration("log-ready-timeout", defaultReadyTimeout, "time limit for each log readiness condition")
+	testTimeout := flag.Duration("test-timeout", 0, "default time limit of each test run, 0 means no limit")
+	requestTimeout := flag.Duration("request-timeout", 0, "default time limit of each request, 0 means the client timeout of 30s")
+	retryAttempts := flag.Int("retry-attempts", 5, "maximum number of attempts per request, 1 disables retries")
+	retryBackoff := flag.Duration("retry-backoff", 50*time.Millisecond, "backoff before the first retry, doubled for every further one")
+	retryMaxBackoff := flag.Duration("retry-max-backoff", 2*time.Second, "upper limit for the retry backoff")
+	retryJitter := flag.Float64("retry-jitter", 0.5, "fraction of the backoff that is randomized")
+	retryOn := flag.String("retry-on", "leader-changed,unavailable", "comma separated error classes that are retried: leader-changed, unavailable, timeout, conflict, connection")
 	flag.Parse()
 	args := flag.Args()
-	if len(args) != 1 {
-		return nil, fmt.Errorf("expected a single position argument, found %d", len(args))
+	if len(args) == 0 {
+		return nil, fmt.Errorf("expected at least one endpoint as positional argument")
+	}
+	for _, e := range args {
+		endpoint, err := url.Parse(e)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse endpoint %s: %w", e, err)
+		}
+		if (endpoint.Scheme == "unix" || endpoint.Scheme == "http+unix") && endpoint.Path == "" {
+			return nil, fmt.Errorf("unix socket endpoint %s requires an absolute path, e.g. unix:///tmp/arangod.sock", e)
+		}
+	}
+
+	loadBalancing, err := parseLoadBalancing(*loadBalancingName)
+	if err != nil {
+		return nil, err
+	}
+
+	connectionMode, err := parseConnectionMode(*connectionModeName)
+	if err != nil {
+		return nil, err
+	}
+
+	readiness, err := parseReadinessConditions(*readyConditions, *readyTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	retryClasses, err := parseErrorClasses(*retryOn)
+	if err != nil {
+		return nil, err
+	}
+
+	var protocols []Protocol
+	for _, name := range strings.Split(*protocolNames, ",") {
+		protocol, err := parseProtocol(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		protocols = append(protocols, protocol)
+	}
+
+	var encodings []Encoding
+	for _, name := range strings.Split(*encodingNames, ",") {
+		encoding, err := parseEncoding(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		encodings = append(encodings, encoding)
+	}
+
+	cassette, err := func() (Cassette, error) {
+		switch {
+		case *recordFile != "" && *replayFile != "":
+			return nil, fmt.Errorf("-record and -replay are mutually exclusive")
+		case *recordFile != "":
+			return NewRecordingCassette(*recordFile)
+		case *replayFile != "":
+			return NewReplayCassette(*replayFile)
+		}
+		return nil, nil
+	}()
+	if err != nil {
+		return nil, err
 	}
 
 	outFile, err := func() (*os.File, error) {
@@ -560,7 +1119,58 @@ func parseArguments() (*Arguments, error) {
 		return nil, fmt.Errorf("failed to open output file: %w", err)
 	}
 
-	return &Arguments{Endpoint: args[0], OutFile: outFile, QuickTests: *quickTests}, nil
+	auth, err := func() (Authentication, error) {
+		switch {
+		case *jwtSecretFile != "" && *username != "":
+			return nil, fmt.Errorf("-username and -jwt-secret-file are mutually exclusive")
+		case *jwtSecretFile != "":
+			return NewSuperuserAuthentication(*jwtSecretFile)
+		case *username != "" && *useJwt:
+			return NewJWTAuthentication(*username, *password), nil
+		case *username != "":
+			return &BasicAuthentication{Username: *username, Password: *password}, nil
+		case *useJwt:
+			return nil, fmt.Errorf("-jwt requires -username")
+		}
+		return nil, nil
+	}()
+	if err != nil {
+		return nil, fmt.Errorf("invalid authentication: %w", err)
+	}
+
+	return &Arguments{
+		Endpoints:         args,
+		LoadBalancing:     loadBalancing,
+		Discover:          *discover,
+		DiscoveryInterval: *discoveryInterval,
+		OutFile:           outFile,
+		QuickTests:        *quickTests,
+		Auth:              auth,
+		TLS: TLSOptions{
+			CAFile:             *caFile,
+			CertFile:           *certFile,
+			KeyFile:            *keyFile,
+			ServerName:         *serverName,
+			InsecureSkipVerify: *insecure,
+		},
+		Protocols:          protocols,
+		Encodings:          encodings,
+		ConnectionMode:     connectionMode,
+		ConnectionPoolSize: *connectionPoolSize,
+		Rate:               *rate,
+		Cassette:           cassette,
+		Readiness:          readiness,
+		WorkerRate:         *workerRate,
+		TestTimeout:        *testTimeout,
+		RequestTimeout:     *requestTimeout,
+		Retry: RetryPolicy{
+			MaxAttempts:    *retryAttempts,
+			InitialBackoff: *retryBackoff,
+			MaxBackoff:     *retryMaxBackoff,
+			Jitter:         *retryJitter,
+			RetryOn:        retryClasses,
+		},
+	}, nil
 }
 
 func main() {
@@ -570,7 +1180,16 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := runAllTests(*args); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = runAllTests(ctx, *args)
+	if args.Cassette != nil {
+		if err := args.Cassette.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to close cassette: %v\n", err)
+		}
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to run all tests: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// QuorumResults describe which participants formed the quorums that
+// committed the inserted entries.
+type QuorumResults struct {
+	// Committed counts the inserts whose response contained a quorum.
+	Committed float64 `json:"committed"`
+	// Compositions counts the quorums by their sorted, comma separated
+	// participants.
+	Compositions map[string]float64 `json:"compositions"`
+	// Participants is the fraction of quorums every participant was part of.
+	Participants map[string]float64 `json:"participants"`
+	// Size is the average number of participants in a quorum.
+	Size float64 `json:"size"`
+}
+
+// QuorumReporter is implemented by tests that collect the quorums of their
+// inserts. QuorumResults is called after all test threads are done.
+type QuorumReporter interface {
+	QuorumResults(ctx context.Context) (QuorumResults, error)
+}
+
+// quorumRecorder collects the quorums of inserts. The zero value is ready to
+// use.
+type quorumRecorder struct {
+	mutex        sync.Mutex
+	committed    int
+	members      int
+	compositions map[string]int
+	participants map[string]int
+}
+
+func (r *quorumRecorder) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.committed, r.members = 0, 0
+	r.compositions, r.participants = nil, nil
+}
+
+func (r *quorumRecorder) record(quorum []string) {
+	if len(quorum) == 0 {
+		return
+	}
+	sorted := append([]string(nil), quorum...)
+	sort.Strings(sorted)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.compositions == nil {
+		r.compositions = make(map[string]int)
+		r.participants = make(map[string]int)
+	}
+	r.committed++
+	r.members += len(sorted)
+	r.compositions[strings.Join(sorted, ",")]++
+	for _, participant := range sorted {
+		r.participants[participant]++
+	}
+}
+
+func (r *quorumRecorder) results() QuorumResults {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	result := QuorumResults{
+		Committed:    float64(r.committed),
+		Compositions: make(map[string]float64, len(r.compositions)),
+		Participants: make(map[string]float64, len(r.participants)),
+	}
+	for composition, n := range r.compositions {
+		result.Compositions[composition] = float64(n)
+	}
+	if r.committed > 0 {
+		for participant, n := range r.participants {
+			result.Participants[participant] = float64(n) / float64(r.committed)
+		}
+		result.Size = float64(r.members) / float64(r.committed)
+	}
+	return result
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestQuorumRecorderZeroValue(t *testing.T) {
+	var r quorumRecorder
+	r.record([]string{"PRMR-2", "PRMR-1"})
+	r.record([]string{"PRMR-1", "PRMR-3"})
+	result := r.results()
+	if result.Committed != 2 || result.Compositions["PRMR-1,PRMR-2"] != 1 || result.Participants["PRMR-1"] != 1 || result.Size != 2 {
+		t.Errorf("unexpected results %+v", result)
+	}
+
+	r.reset()
+	r.record([]string{"PRMR-1"})
+	if result := r.results(); result.Committed != 1 || len(result.Compositions) != 1 {
+		t.Errorf("unexpected results after reset %+v", result)
+	}
+}
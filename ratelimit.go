@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter is a token bucket that allows rate requests per second with
+// bursts of up to burst requests.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: 1, tokens: 1, last: time.Now()}
+}
+
+// take removes a token from the bucket and returns the time until it would
+// have been available. The bucket may go into debt, so requests taking
+// tokens concurrently queue up behind each other.
+func (l *rateLimiter) take(now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait blocks until the next request may be sent and returns the time it
+// waited.
+func (l *rateLimiter) wait(ctx context.Context) (time.Duration, error) {
+	now := time.Now()
+	delay := l.take(now)
+	if delay <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return time.Since(now), ctx.Err()
+	}
+}
+
+type RateLimitResults struct {
+	// Target is the configured number of requests per second, Achieved the
+	// number of requests that actually passed the limiter per second.
+	Target   float64 `json:"target"`
+	Achieved float64 `json:"achieved"`
+	// Wait is the total time the workers spent waiting on the limiter,
+	// AvgWait the average per request.
+	Wait    float64 `json:"wait"`
+	AvgWait float64 `json:"avgWait"`
+}
+
+// withRateLimit returns a copy of the context whose requests are limited to
+// rate requests per second in total and workerRate requests per second for
+// every worker. Zero disables the respective limit.
+func (c *Context) withRateLimit(rate, workerRate float64) *Context {
+	result := *c
+	result.limiter, result.workerLimiter = nil, nil
+	if rate > 0 {
+		result.limiter = newRateLimiter(rate)
+	}
+	result.workerRate = workerRate
+	return &result
+}
+
+// waitForRateLimit waits until both the global and the worker limiter allow
+// another request. Test threads call it before they start timing a request,
+// so that throttling is not counted as latency.
+func (c *Context) waitForRateLimit(ctx context.Context) error {
+	if c.limiter == nil && c.workerLimiter == nil {
+		return nil
+	}
+	var total time.Duration
+	for _, l := range []*rateLimiter{c.limiter, c.workerLimiter} {
+		if l == nil {
+			continue
+		}
+		waited, err := l.wait(ctx)
+		total += waited
+		if err != nil {
+			atomic.AddInt64(&c.stats.rateLimitWait, int64(total))
+			return err
+		}
+	}
+	atomic.AddInt64(&c.stats.rateLimitWait, int64(total))
+	atomic.AddInt64(&c.stats.rateLimited, 1)
+	return nil
+}
+
+// reserveRateLimit takes a token from both limiters without waiting. It is
+// used for retries, which are part of a request that already waited.
+func (c *Context) reserveRateLimit() {
+	if c.limiter == nil && c.workerLimiter == nil {
+		return
+	}
+	now := time.Now()
+	for _, l := range []*rateLimiter{c.limiter, c.workerLimiter} {
+		if l != nil {
+			l.take(now)
+		}
+	}
+	atomic.AddInt64(&c.stats.rateLimited, 1)
+}
+
+// targetRate returns the rate the limiters of the context allow for the
+// given number of workers, zero if they are not limited.
+func (c *Context) targetRate(workers int) float64 {
+	var rate float64
+	if c.limiter != nil {
+		rate = c.limiter.rate
+	}
+	if c.workerRate > 0 {
+		if total := c.workerRate * float64(workers); rate == 0 || total < rate {
+			rate = total
+		}
+	}
+	return rate
+}
+
+func (s *contextStats) rateLimitResults(target float64, duration time.Duration) RateLimitResults {
+	requests := float64(atomic.LoadInt64(&s.rateLimited))
+	wait := time.Duration(atomic.LoadInt64(&s.rateLimitWait)).Seconds()
+	result := RateLimitResults{Target: target, Wait: wait}
+	if duration > 0 {
+		result.Achieved = requests / duration.Seconds()
+	}
+	if requests > 0 {
+		result.AvgWait = wait / requests
+	}
+	return result
+}
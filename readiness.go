@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadinessCondition is a state a replicated log has to reach before a test
+// starts writing to it.
+type ReadinessCondition struct {
+	Name    string
+	Timeout time.Duration
+	// check returns a description of what is still missing, or an empty
+	// string if the condition holds.
+	check func(status *ReplicatedLogStatus) string
+}
+
+// defaultReadyTimeout is used for conditions without a timeout.
+const defaultReadyTimeout = time.Minute
+
+func LeaderElected(timeout time.Duration) ReadinessCondition {
+	return ReadinessCondition{Name: "leader-elected", Timeout: timeout, check: func(s *ReplicatedLogStatus) string {
+		if s.Leader == "" {
+			return fmt.Sprintf("no leader in term %d", s.Term)
+		}
+		return ""
+	}}
+}
+
+func LeaderEstablished(timeout time.Duration) ReadinessCondition {
+	return ReadinessCondition{Name: "leader-established", Timeout: timeout, check: func(s *ReplicatedLogStatus) string {
+		if s.Leader == "" {
+			return fmt.Sprintf("no leader in term %d", s.Term)
+		}
+		leader, ok := s.Participants[s.Leader]
+		switch {
+		case !ok:
+			return fmt.Sprintf("no status of leader %s", s.Leader)
+		case leader.ErrorCode != 0:
+			return fmt.Sprintf("leader %s not reachable: %s", s.Leader, leader.ErrorMessage)
+		case leader.Term != s.Term:
+			return fmt.Sprintf("leader %s is in term %d, expected %d", s.Leader, leader.Term, s.Term)
+		case !leader.LeadershipEstablished:
+			return fmt.Sprintf("leader %s has not established its leadership in term %d", s.Leader, s.Term)
+		}
+		return ""
+	}}
+}
+
+// ParticipantsInSync requires every participant of the current
+// configuration to have all entries the leader has committed.
+func ParticipantsInSync(timeout time.Duration) ReadinessCondition {
+	return ReadinessCondition{Name: "in-sync", Timeout: timeout, check: func(s *ReplicatedLogStatus) string {
+		var missing []string
+		for _, name := range s.participantNames() {
+			p, ok := s.Participants[name]
+			switch {
+			case !ok:
+				missing = append(missing, fmt.Sprintf("%s: no status", name))
+			case p.ErrorCode != 0:
+				missing = append(missing, fmt.Sprintf("%s: not reachable: %s", name, p.ErrorMessage))
+			case p.Term != s.Term:
+				missing = append(missing, fmt.Sprintf("%s: in term %d, expected %d", name, p.Term, s.Term))
+			case p.Spearhead.Index < s.CommitIndex:
+				missing = append(missing, fmt.Sprintf("%s: at index %d, leader committed %d", name, p.Spearhead.Index, s.CommitIndex))
+			}
+		}
+		if s.CommitIndex == 0 {
+			missing = append(missing, "leader has not committed anything")
+		}
+		return strings.Join(missing, ", ")
+	}}
+}
+
+func CommitIndexReached(index uint64, timeout time.Duration) ReadinessCondition {
+	return ReadinessCondition{Name: fmt.Sprintf("commit-index=%d", index), Timeout: timeout, check: func(s *ReplicatedLogStatus) string {
+		if s.CommitIndex < index {
+			return fmt.Sprintf("commit index is %d, expected %d", s.CommitIndex, index)
+		}
+		return ""
+	}}
+}
+
+// participantNames returns the participants of the configuration, or the
+// ones that reported their status if the configuration is unknown.
+func (s *ReplicatedLogStatus) participantNames() []string {
+	var names []string
+	for name := range s.Configuration.Participants {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		for name := range s.Participants {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseReadinessConditions parses a comma separated list of condition
+// names, commit-index=N requires the given commit index.
+func parseReadinessConditions(s string, timeout time.Duration) ([]ReadinessCondition, error) {
+	var result []ReadinessCondition
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case name == "leader-elected":
+			result = append(result, LeaderElected(timeout))
+		case name == "leader-established":
+			result = append(result, LeaderEstablished(timeout))
+		case name == "in-sync":
+			result = append(result, ParticipantsInSync(timeout))
+		case strings.HasPrefix(name, "commit-index="):
+			index, err := strconv.ParseUint(strings.TrimPrefix(name, "commit-index="), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid readiness condition %q: %w", name, err)
+			}
+			result = append(result, CommitIndexReached(index, timeout))
+		default:
+			return nil, fmt.Errorf("unknown readiness condition %q", name)
+		}
+	}
+	return result, nil
+}
+
+// waitForReplicatedLog waits until the log satisfies the readiness
+// conditions of the context, one after the other. Each condition has to be
+// satisfied within its own timeout.
+func (c *Context) waitForReplicatedLog(ctx context.Context, id uint) error {
+	conditions := c.readiness
+	if len(conditions) == 0 {
+		conditions = []ReadinessCondition{LeaderElected(defaultReadyTimeout)}
+	}
+	for _, condition := range conditions {
+		if err := c.waitForCondition(ctx, id, condition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForCondition polls the status of the log until the condition holds.
+// Errors, such as a connection reset during a leader change, are retried
+// until the timeout of the condition. If the last status request failed, the
+// returned error wraps its *ArangoError, so that it can still be classified.
+// Otherwise an *ArangoError is returned whose Cause wraps the error of ctx.
+func (c *Context) waitForCondition(ctx context.Context, id uint, condition ReadinessCondition) error {
+	timeout := condition.Timeout
+	if timeout <= 0 {
+		timeout = defaultReadyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	missing := "no status received"
+	var lastErr error
+	for {
+		status, err := c.GetReplicatedLogStatus(ctx, id)
+		switch {
+		case err == nil:
+			lastErr = nil
+			if missing = condition.check(status); missing == "" {
+				return nil
+			}
+		case ctx.Err() == nil:
+			lastErr = err
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			message := fmt.Sprintf("replicated log %d not ready, condition %s not met within %v: %s", id, condition.Name, timeout, missing)
+			if lastErr != nil {
+				return fmt.Errorf("%s, last error: %w", message, lastErr)
+			}
+			return &ArangoError{Method: "GET", Path: fmt.Sprintf("_api/log/%d", id), Cause: fmt.Errorf("%s: %w", message, ctx.Err())}
+		}
+	}
+}
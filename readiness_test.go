@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitForConditionRetriesTransportErrors(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the first requests fail like during a leader change
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logStatusResponse("PRMR-1"))
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.waitForCondition(context.Background(), 1, LeaderElected(5*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("%d requests, expected 3", n)
+	}
+}
+
+func TestWaitForConditionTimeout(t *testing.T) {
+	c, _ := (&cassetteRecorder{t: t}).replay(ContextOptions{})
+	err := c.waitForCondition(context.Background(), 1, LeaderElected(300*time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "last error") || !strings.Contains(err.Error(), "no recorded response left") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, ok := asArangoError(err); !ok {
+		t.Errorf("%v is no ArangoError", err)
+	}
+}
+
+func TestWaitForConditionClassifiesLastError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error":true,"code":404,"errorNum":%d,"errorMessage":"log not found"}`, ErrorReplicatedLogNotFound)
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.waitForCondition(context.Background(), 1, LeaderElected(300*time.Millisecond))
+	if !IsNotFound(err) {
+		t.Errorf("%v is not classified as not found", err)
+	}
+}
+
+func TestWaitForConditionNotMet(t *testing.T) {
+	r := &cassetteRecorder{t: t}
+	for i := 0; i < 10; i++ {
+		r.add("GET", "/_api/log/1", nil, 200, logStatusResponse(""))
+	}
+	c, _ := r.replay(ContextOptions{})
+	err := c.waitForCondition(context.Background(), 1, LeaderElected(300*time.Millisecond))
+	if _, ok := asArangoError(err); !ok || !IsTimeout(err) || !strings.Contains(err.Error(), "no leader in term 2") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
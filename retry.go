@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type ErrorClass string
+
+const (
+	RetryLeaderChanged ErrorClass = "leader-changed"
+	RetryUnavailable   ErrorClass = "unavailable"
+	RetryTimeout       ErrorClass = "timeout"
+	RetryConflict      ErrorClass = "conflict"
+	RetryConnection    ErrorClass = "connection"
+)
+
+var errorClasses = map[ErrorClass]func(error) bool{
+	RetryLeaderChanged: IsLeaderChanged,
+	RetryUnavailable:   IsUnavailable,
+	RetryTimeout:       IsTimeout,
+	RetryConflict:      IsConflict,
+	RetryConnection:    IsConnectionError,
+}
+
+func parseErrorClasses(s string) ([]ErrorClass, error) {
+	var result []ErrorClass
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := errorClasses[ErrorClass(name)]; !ok {
+			return nil, fmt.Errorf("unknown error class %q", name)
+		}
+		result = append(result, ErrorClass(name))
+	}
+	return result, nil
+}
+
+// RetryPolicy describes how often and how long a failed request is retried.
+// The zero value does not retry at all.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction of the backoff that is randomized, 0.5 means
+	// the actual backoff lies between 50% and 100% of the computed one.
+	Jitter  float64
+	RetryOn []ErrorClass
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	for _, class := range p.RetryOn {
+		if errorClasses[class](err) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the time to wait before the given attempt, starting with
+// the second one at 1.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff << (attempt - 1)
+	if backoff <= 0 || (p.MaxBackoff > 0 && backoff > p.MaxBackoff) {
+		backoff = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		backoff -= time.Duration(rand.Float64() * p.Jitter * float64(backoff))
+	}
+	return backoff
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not
+// retryable or the attempts are exhausted. Retries take their tokens from
+// the rate limiters without waiting, the next request of the worker waits
+// for them instead.
+func (c *Context) withRetry(ctx context.Context, fn func() error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		attemptStart := time.Now()
+		err := fn()
+		if err == nil || attempt >= c.retry.MaxAttempts || !c.retry.retryable(err) || ctx.Err() != nil {
+			if attempt > 1 {
+				atomic.AddInt64(&c.stats.retryTime, int64(attemptStart.Sub(start)))
+			}
+			return err
+		}
+
+		atomic.AddInt64(&c.stats.retries, 1)
+		select {
+		case <-time.After(c.retry.backoff(attempt)):
+		case <-ctx.Done():
+			atomic.AddInt64(&c.stats.retryTime, int64(time.Since(start)))
+			return err
+		}
+		c.reserveRateLimit()
+	}
+}
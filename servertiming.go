@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const queueTimeHeader = "X-Arango-Queue-Time-Seconds"
+
+// serverTimingRecorder collects the timing information servers report in
+// their response headers.
+type serverTimingRecorder struct {
+	mutex     sync.Mutex
+	queueTime []time.Duration
+	remainder []time.Duration
+	timings   map[string][]time.Duration
+}
+
+func (r *serverTimingRecorder) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.queueTime, r.remainder, r.timings = nil, nil, nil
+}
+
+// record adds the server timings of a response to a request that took
+// total from start until its body was read.
+func (r *serverTimingRecorder) record(header http.Header, total time.Duration) {
+	queueTime, hasQueueTime := parseQueueTime(header)
+	timings := parseServerTiming(header)
+	if !hasQueueTime && len(timings) == 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if hasQueueTime {
+		r.queueTime = append(r.queueTime, queueTime)
+		r.remainder = append(r.remainder, total-queueTime)
+	}
+	for name, duration := range timings {
+		if r.timings == nil {
+			r.timings = make(map[string][]time.Duration)
+		}
+		r.timings[name] = append(r.timings[name], duration)
+	}
+}
+
+func parseQueueTime(header http.Header) (time.Duration, bool) {
+	value := header.Get(queueTimeHeader)
+	if value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// parseServerTiming parses `Server-Timing: name;dur=1.5, other;dur=3` headers,
+// durations are given in milliseconds.
+func parseServerTiming(header http.Header) map[string]time.Duration {
+	var result map[string]time.Duration
+	for _, value := range header.Values("Server-Timing") {
+		for _, metric := range strings.Split(value, ",") {
+			params := strings.Split(metric, ";")
+			name := strings.TrimSpace(params[0])
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || kv[0] != "dur" {
+					continue
+				}
+				ms, err := strconv.ParseFloat(strings.Trim(kv[1], `"`), 64)
+				if err != nil {
+					continue
+				}
+				if result == nil {
+					result = make(map[string]time.Duration)
+				}
+				result[name] = time.Duration(ms * float64(time.Millisecond))
+			}
+		}
+	}
+	return result
+}
+
+type ServerResults struct {
+	// QueueTime is the time requests spent in the scheduler queue of the
+	// coordinator, Remainder the rest of the request latency, i.e. network
+	// and processing.
+	QueueTime LatencyDistribution            `json:"queueTime"`
+	Remainder LatencyDistribution            `json:"networkAndProcessing"`
+	Timing    map[string]LatencyDistribution `json:"timing,omitempty"`
+}
+
+func (r *serverTimingRecorder) results() ServerResults {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	result := ServerResults{
+		QueueTime: calcDistribution(r.queueTime),
+		Remainder: calcDistribution(r.remainder),
+	}
+	for name, samples := range r.timings {
+		if result.Timing == nil {
+			result.Timing = make(map[string]LatencyDistribution)
+		}
+		result.Timing[name] = calcDistribution(samples)
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+type TLSOptions struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func (o *TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key have to be specified together")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// normalizeEndpoint maps the arangod endpoint schemes to their http
+// equivalents, i.e. tcp:// becomes http:// and ssl:// becomes https://.
+// Unix domain sockets are given a host name, see unixSocketEndpoint.
+func normalizeEndpoint(endpoint *url.URL) *url.URL {
+	result := *endpoint
+	switch result.Scheme {
+	case "unix", "http+unix":
+		result = unixSocketEndpoint(result.Path)
+	case "tcp", "http+tcp":
+		result.Scheme = "http"
+	case "ssl", "http+ssl":
+		result.Scheme = "https"
+	}
+	return &result
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// requestTrace records the points in time a single request passes through.
+// Timestamps that were not reached stay zero.
+type requestTrace struct {
+	mutex        sync.Mutex
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+func (t *requestTrace) set(field *time.Time) func() {
+	return func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		*field = time.Now()
+	}
+}
+
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	connectStart, connectDone := t.set(&t.connectStart), t.set(&t.connectDone)
+	tlsDone, gotConn, wroteRequest := t.set(&t.tlsDone), t.set(&t.gotConn), t.set(&t.wroteRequest)
+	return &httptrace.ClientTrace{
+		ConnectStart:         func(string, string) { connectStart() },
+		ConnectDone:          func(string, string, error) { connectDone() },
+		TLSHandshakeStart:    t.set(&t.tlsStart),
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tlsDone() },
+		GotConn:              func(httptrace.GotConnInfo) { gotConn() },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wroteRequest() },
+		GotFirstResponseByte: t.set(&t.firstByte),
+	}
+}
+
+// phaseRecorder collects the duration of the phases of all traced requests.
+type phaseRecorder struct {
+	mutex     sync.Mutex
+	connect   []time.Duration
+	tls       []time.Duration
+	write     []time.Duration
+	firstByte []time.Duration
+	bodyRead  []time.Duration
+}
+
+func (r *phaseRecorder) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.connect, r.tls, r.write, r.firstByte, r.bodyRead = nil, nil, nil, nil, nil
+}
+
+// record adds the phases of a request whose body was read completely at
+// done.
+func (r *phaseRecorder) record(t *requestTrace, done time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	between := func(samples *[]time.Duration, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			*samples = append(*samples, to.Sub(from))
+		}
+	}
+	between(&r.connect, t.connectStart, t.connectDone)
+	between(&r.tls, t.tlsStart, t.tlsDone)
+	between(&r.write, t.gotConn, t.wroteRequest)
+	between(&r.firstByte, t.wroteRequest, t.firstByte)
+	between(&r.bodyRead, t.firstByte, done)
+}
+
+type PhaseResults struct {
+	Connect         LatencyDistribution `json:"connect"`
+	TLS             LatencyDistribution `json:"tls"`
+	Write           LatencyDistribution `json:"write"`
+	TimeToFirstByte LatencyDistribution `json:"ttfb"`
+	BodyRead        LatencyDistribution `json:"read"`
+}
+
+func (r *phaseRecorder) results() PhaseResults {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return PhaseResults{
+		Connect:         calcDistribution(r.connect),
+		TLS:             calcDistribution(r.tls),
+		Write:           calcDistribution(r.write),
+		TimeToFirstByte: calcDistribution(r.firstByte),
+		BodyRead:        calcDistribution(r.bodyRead),
+	}
+}
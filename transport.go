@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+type Protocol string
+
+const (
+	HTTP1 Protocol = "http1"
+	// HTTP2 uses ALPN on TLS connections and prior knowledge (h2c) on
+	// cleartext connections. The client does not negotiate h2c with an
+	// HTTP/1.1 Upgrade, arangod accepts the connection preface directly, and
+	// an upgrade would only add a round trip to every new connection.
+	HTTP2 Protocol = "http2"
+	VST   Protocol = "vst"
+)
+
+func parseProtocol(s string) (Protocol, error) {
+	switch p := Protocol(s); p {
+	case HTTP1, HTTP2, VST:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown protocol %q", s)
+}
+
+// clientTimeout is the overall limit for a single request if no request
+// timeout is configured. It is applied by Context.send, the clients have no
+// timeout of their own, which would cap longer request timeouts.
+const clientTimeout = 30 * time.Second
+
+// connectionOptions control how a client manages its connections.
+type connectionOptions struct {
+	// maxConnsPerHost limits the connections to a single coordinator, zero
+	// means no limit.
+	maxConnsPerHost   int
+	disableKeepAlives bool
+	dial              func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func newHTTPClient(tlsConfig *tls.Config, protocol Protocol, opts connectionOptions) *http.Client {
+	if protocol == VST {
+		connections := vstConnectionsPerHost
+		if opts.maxConnsPerHost > 0 {
+			connections = opts.maxConnsPerHost
+		}
+		transport := newVSTTransport(tlsConfig, connections)
+		transport.disableKeepAlives = opts.disableKeepAlives
+		transport.dialContext = opts.dial
+		return &http.Client{Transport: transport}
+	}
+
+	if protocol == HTTP2 {
+		return &http.Client{Transport: newHTTP2RoundTripper(tlsConfig, opts)}
+	}
+	transport := newTransport(tlsConfig, opts)
+	// a non-nil map keeps the transport from switching to HTTP/2 via ALPN
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	return &http.Client{Transport: transport}
+}
+
+func newTransport(tlsConfig *tls.Config, opts connectionOptions) *http.Transport {
+	// configuring HTTP/2 changes the ALPN protocols, which must not affect
+	// the other clients sharing the config
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+	}
+	return &http.Transport{
+		MaxIdleConnsPerHost: 1000,
+		MaxConnsPerHost:     opts.maxConnsPerHost,
+		DisableKeepAlives:   opts.disableKeepAlives,
+		DialContext:         opts.dial,
+		TLSClientConfig:     tlsConfig,
+	}
+}
+
+// newHTTP2RoundTripper returns the transport of an HTTP/2 client. HTTP/2
+// multiplexes all requests to a host over a single connection, so a limit
+// of N connections per host is implemented by N transports with one
+// connection each, which take turns.
+func newHTTP2RoundTripper(tlsConfig *tls.Config, opts connectionOptions) http.RoundTripper {
+	if opts.maxConnsPerHost <= 0 {
+		return newHTTP2Transport(tlsConfig, opts)
+	}
+	pool := &http2TransportPool{}
+	single := opts
+	single.maxConnsPerHost = 1
+	for i := 0; i < opts.maxConnsPerHost; i++ {
+		pool.transports = append(pool.transports, newHTTP2Transport(tlsConfig, single))
+	}
+	return pool
+}
+
+// http2TransportPool distributes requests round robin over its transports.
+type http2TransportPool struct {
+	transports []*http2Transport
+	next       uint64
+}
+
+func (p *http2TransportPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := p.transports[atomic.AddUint64(&p.next, 1)%uint64(len(p.transports))]
+	return t.RoundTrip(req)
+}
+
+func (p *http2TransportPool) CloseIdleConnections() {
+	for _, t := range p.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+// http2Transport sends https requests via the HTTP/2 support of
+// http.Transport and http requests via h2c with prior knowledge.
+type http2Transport struct {
+	tls  *http.Transport
+	h2c  *http2.Transport
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// disableKeepAlives makes every h2c request use a connection of its own.
+	disableKeepAlives bool
+}
+
+// newHTTP2Transport creates a transport that opens at most one connection
+// per host if opts limits the connections, requests wait for a free stream
+// on it instead of opening another one.
+func newHTTP2Transport(tlsConfig *tls.Config, opts connectionOptions) *http2Transport {
+	transport := newTransport(tlsConfig, opts)
+	strict := opts.maxConnsPerHost > 0
+	h2, err := http2.ConfigureTransports(transport)
+	if err != nil {
+		// only fails if the transport was configured before
+		panic(err)
+	}
+	h2.StrictMaxConcurrentStreams = strict
+	dial := opts.dial
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	return &http2Transport{
+		tls: transport,
+		h2c: &http2.Transport{
+			AllowHTTP:                  true,
+			StrictMaxConcurrentStreams: strict,
+			// the context of the request limits the dial
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		},
+		dial:              dial,
+		disableKeepAlives: opts.disableKeepAlives,
+	}
+}
+
+func (t *http2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" {
+		return t.tls.RoundTrip(req)
+	}
+	if !t.disableKeepAlives {
+		return t.h2c.RoundTrip(req)
+	}
+
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+	}
+	conn, err := t.dial(req.Context(), "tcp", net.JoinHostPort(req.URL.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	cc, err := t.h2c.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := cc.RoundTrip(req)
+	if err != nil {
+		cc.Close()
+		return nil, err
+	}
+	resp.Body = &connClosingBody{resp.Body, cc}
+	return resp, nil
+}
+
+// connClosingBody closes the connection of a response with its body.
+type connClosingBody struct {
+	io.ReadCloser
+	conn io.Closer
+}
+
+func (b *connClosingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.conn.Close()
+	return err
+}
+
+func (t *http2Transport) CloseIdleConnections() {
+	t.tls.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
+
+// newClient creates a client for the protocol of the context, whose
+// connections are counted in the context stats.
+func (c *Context) newClient(opts connectionOptions) *http.Client {
+	var dialer net.Dialer
+	dial := dialUnixSockets(dialer.DialContext)
+	opts.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err == nil {
+			atomic.AddInt64(&c.stats.connections, 1)
+		}
+		return conn, err
+	}
+	client := newHTTPClient(c.tlsConfig, c.protocol, opts)
+	if c.cassette != nil {
+		client.Transport = c.cassette.wrap(client.Transport)
+	}
+	return client
+}
+
+// withProtocol returns a copy of the context that sends its requests using
+// the given protocol.
+func (c *Context) withProtocol(protocol Protocol) *Context {
+	result := *c
+	result.protocol = protocol
+	result.Client = result.newClient(connectionOptions{})
+	return &result
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestHTTP2Cleartext(t *testing.T) {
+	var requests, http1 int32
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if req.ProtoMajor != 2 {
+			atomic.AddInt32(&http1, 1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}), &http2.Server{}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{Protocol: HTTP2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := c.withConnectionMode(TestSettings{ConnectionMode: NoKeepAlive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Context{c, c, nc, nc} {
+		if err := c.dropReplicatedLog(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 4 || http1 != 0 {
+		t.Errorf("%d requests, %d of them with HTTP/1", requests, http1)
+	}
+	if n := c.stats.connections; n != 3 {
+		t.Errorf("%d connections, expected 3", n)
+	}
+}
+
+func TestHTTP1AfterHTTP2OverTLS(t *testing.T) {
+	var protocols []string
+	var mutex sync.Mutex
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		protocols = append(protocols, req.Proto)
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{TLS: TLSOptions{InsecureSkipVerify: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Context{c.withProtocol(HTTP2), c.withProtocol(HTTP1), c} {
+		if err := c.dropReplicatedLog(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(protocols) != 3 || protocols[0] != "HTTP/2.0" || protocols[1] != "HTTP/1.1" || protocols[2] != "HTTP/1.1" {
+		t.Errorf("unexpected protocols %v", protocols)
+	}
+}
+
+func TestHTTP2CleartextDialIsCancelled(t *testing.T) {
+	client := newHTTPClient(nil, HTTP2, connectionOptions{dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// a dial that only ends with its context, like one to a lost server
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://unreachable.invalid:8529/_api/version", nil)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Do(req)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial was not cancelled with the request")
+	}
+}
+
+func TestHTTP2ConnectionPool(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	})
+	cleartext := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer cleartext.Close()
+	encrypted := httptest.NewUnstartedServer(handler)
+	encrypted.EnableHTTP2 = true
+	encrypted.StartTLS()
+	defer encrypted.Close()
+
+	for _, server := range []*httptest.Server{cleartext, encrypted} {
+		endpoint, _ := url.Parse(server.URL)
+		c, err := NewContext([]url.URL{*endpoint}, ContextOptions{Protocol: HTTP2, TLS: TLSOptions{InsecureSkipVerify: true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc, err := c.withConnectionMode(TestSettings{ConnectionMode: PooledConnections, ConnectionPoolSize: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := pc.dropReplicatedLog(context.Background(), 1); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := atomic.LoadInt64(&c.stats.connections); n != 2 {
+			t.Errorf("%s: %d connections, expected 2", endpoint.Scheme, n)
+		}
+		pc.Client.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// unixSockets maps the host names of unix domain socket endpoints to the
+// socket paths. Requests need a host, so unix:///path/to/socket endpoints are
+// rewritten to http://<name>.unix and the dialer resolves the name again.
+var unixSockets sync.Map
+
+// unixSocketEndpoint returns the http endpoint for the socket at path. The
+// host name keeps the path readable, a hash of the path tells apart paths
+// that differ only in the replaced characters.
+func unixSocketEndpoint(path string) url.URL {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.Trim(path, "/"))
+	sum := sha256.Sum256([]byte(path))
+	host := fmt.Sprintf("%s-%x.unix", name, sum[:8])
+	unixSockets.Store(host, path)
+	return url.URL{Scheme: "http", Host: host}
+}
+
+// dialUnixSockets wraps dial such that addresses of unix socket endpoints
+// are dialed via their socket.
+func dialUnixSockets(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if path, ok := unixSockets.Load(host); ok {
+			return dial(ctx, "unix", path.(string))
+		}
+		return dial(ctx, network, addr)
+	}
+}
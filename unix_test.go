@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSocketEndpoint(t *testing.T) {
+	a := unixSocketEndpoint("/tmp/a-b.sock")
+	b := unixSocketEndpoint("/tmp/a/b.sock")
+	if a.Host == b.Host {
+		t.Errorf("%s and %s map to the same host", "/tmp/a-b.sock", "/tmp/a/b.sock")
+	}
+	for host, path := range map[string]string{a.Host: "/tmp/a-b.sock", b.Host: "/tmp/a/b.sock"} {
+		if stored, _ := unixSockets.Load(host); stored != path {
+			t.Errorf("%s resolves to %v, expected %s", host, stored, path)
+		}
+	}
+}
+
+// listenUnix listens on a socket in a new temporary directory, whose path
+// is short enough for a socket address.
+func listenUnix(t *testing.T) (net.Listener, *url.URL) {
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "arangod.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, &url.URL{Scheme: "unix", Path: path}
+}
+
+func TestUnixSocketRequest(t *testing.T) {
+	l, endpoint := listenUnix(t)
+	received := make(chan string, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received <- req.Method + " " + req.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Health":{"PRMR-1":{"Role":"DBServer","Status":"GOOD"}}}`))
+	}))
+	server.Listener.Close()
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers, err := c.getHealthyDBServers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0] != "PRMR-1" {
+		t.Errorf("unexpected servers %v", servers)
+	}
+	if r := <-received; r != "GET /_admin/cluster/health" {
+		t.Errorf("unexpected request %s", r)
+	}
+	if c.stats.connections != 1 {
+		t.Errorf("%d connections, expected 1", c.stats.connections)
+	}
+}
+
+func TestUnixSocketVSTRequest(t *testing.T) {
+	l, endpoint := listenUnix(t)
+	received := make(chan vstTestRequest, 1)
+	serveVSTTestServer(t, l, func(req vstTestRequest) vstTestResponse {
+		received <- req
+		return vstTestResponse{
+			Code: http.StatusOK,
+			Meta: map[string]interface{}{"content-type": vstContentVelocypack},
+			Body: vpackBody(t, map[string]interface{}{"result": map[string]interface{}{"leaderId": "PRMR-1"}}),
+		}
+	})
+
+	c, err := NewContext([]url.URL{*endpoint}, ContextOptions{Protocol: VST})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.GetReplicatedLogStatus(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Leader != "PRMR-1" {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if req := <-received; req.Path != "/_api/log/5" || req.Type != vstRequestTypes["GET"] {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A minimal VelocyPack implementation, see
+// https://github.com/arangodb/velocypack/blob/main/VelocyPack.md
+
+// vpackAppend appends the VelocyPack encoding of v to buf. Values are
+// encoded like encoding/json does, i.e. struct fields are named by their
+// json tags.
+func vpackAppend(buf []byte, v interface{}) ([]byte, error) {
+	return vpackAppendValue(buf, reflect.ValueOf(v))
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+func vpackAppendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(buf, 0x18), nil
+	}
+	if v.Type().Implements(jsonMarshalerType) && (v.Kind() != reflect.Ptr || !v.IsNil()) {
+		// types with custom json encoding take a detour via their json
+		data, err := v.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return nil, err
+		}
+		return vpackAppend(buf, generic)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(buf, 0x18), nil
+		}
+		return vpackAppendValue(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 0x1a), nil
+		}
+		return append(buf, 0x19), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return vpackAppendInt(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return vpackAppendUint(buf, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return vpackAppendDouble(buf, v.Float()), nil
+	case reflect.String:
+		return vpackAppendString(buf, v.String()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return append(buf, 0x18), nil
+		}
+		items := make([][]byte, v.Len())
+		for i := range items {
+			var err error
+			if items[i], err = vpackAppendValue(nil, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return vpackAppendArray(buf, items), nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(buf, 0x18), nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot encode %s as velocypack", v.Type())
+		}
+		keys := make([]string, 0, v.Len())
+		values := make([][]byte, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			data, err := vpackAppendValue(nil, iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, iter.Key().String())
+			values = append(values, data)
+		}
+		return vpackAppendObject(buf, keys, values), nil
+	case reflect.Struct:
+		var keys []string
+		var values [][]byte
+		for _, f := range vpackFields(v.Type()) {
+			field := v.FieldByIndex(f.index)
+			if f.omitEmpty && field.IsZero() {
+				continue
+			}
+			data, err := vpackAppendValue(nil, field)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, f.name)
+			values = append(values, data)
+		}
+		return vpackAppendObject(buf, keys, values), nil
+	}
+	return nil, fmt.Errorf("cannot encode %s as velocypack", v.Type())
+}
+
+type vpackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// vpackFields returns the encoded fields of a struct type following the
+// rules of encoding/json for tags and embedded structs.
+func vpackFields(t reflect.Type) []vpackField {
+	var fields []vpackField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, options = tag[:idx], tag[idx+1:]
+		}
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			for _, f := range vpackFields(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, vpackField{
+			name:      name,
+			index:     []int{i},
+			omitEmpty: strings.Contains(","+options+",", ",omitempty,"),
+		})
+	}
+	return fields
+}
+
+func vpackAppendInt(buf []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 9:
+		return append(buf, 0x30+byte(v))
+	case v >= -6 && v < 0:
+		return append(buf, byte(0x40+v))
+	}
+	n := 1
+	for n < 8 && (v < -(1<<(8*n-1)) || v >= 1<<(8*n-1)) {
+		n++
+	}
+	return vpackAppendLE(append(buf, 0x1f+byte(n)), uint64(v), n)
+}
+
+func vpackAppendUint(buf []byte, v uint64) []byte {
+	if v <= 9 {
+		return append(buf, 0x30+byte(v))
+	}
+	n := 1
+	for n < 8 && v >= 1<<(8*n) {
+		n++
+	}
+	return vpackAppendLE(append(buf, 0x27+byte(n)), v, n)
+}
+
+func vpackAppendDouble(buf []byte, v float64) []byte {
+	return vpackAppendLE(append(buf, 0x1b), math.Float64bits(v), 8)
+}
+
+func vpackAppendString(buf []byte, s string) []byte {
+	if len(s) <= 126 {
+		buf = append(buf, 0x40+byte(len(s)))
+	} else {
+		buf = vpackAppendLE(append(buf, 0xbf), uint64(len(s)), 8)
+	}
+	return append(buf, s...)
+}
+
+func vpackAppendLE(buf []byte, v uint64, n int) []byte {
+	for i := 0; i < n; i++ {
+		buf = append(buf, byte(v>>(8*i)))
+	}
+	return buf
+}
+
+// vpackIndexWidth returns the smallest width of the length fields and index
+// table entries of a compound value with the given payload.
+func vpackIndexWidth(payload, items int) int {
+	for _, width := range []int{1, 2, 4} {
+		size := 1 + 2*width + payload + items*width
+		if size < 1<<(8*width) {
+			return width
+		}
+	}
+	return 8
+}
+
+// vpackAppendIndexed appends an indexed array or object. offsets are the
+// positions of the items within payload.
+func vpackAppendIndexed(buf []byte, head byte, payload []byte, offsets []int) []byte {
+	width := vpackIndexWidth(len(payload), len(offsets))
+	headerSize := 1 + 2*width
+	if width == 8 {
+		headerSize = 1 + width
+	}
+	total := headerSize + len(payload) + len(offsets)*width
+	if width == 8 {
+		total += width
+	}
+
+	switch width {
+	case 1:
+		buf = append(buf, head)
+	case 2:
+		buf = append(buf, head+1)
+	case 4:
+		buf = append(buf, head+2)
+	default:
+		buf = append(buf, head+3)
+	}
+	buf = vpackAppendLE(buf, uint64(total), width)
+	if width != 8 {
+		buf = vpackAppendLE(buf, uint64(len(offsets)), width)
+	}
+	buf = append(buf, payload...)
+	for _, offset := range offsets {
+		buf = vpackAppendLE(buf, uint64(headerSize+offset), width)
+	}
+	if width == 8 {
+		buf = vpackAppendLE(buf, uint64(len(offsets)), width)
+	}
+	return buf
+}
+
+func vpackAppendArray(buf []byte, items [][]byte) []byte {
+	if len(items) == 0 {
+		return append(buf, 0x01)
+	}
+	var payload []byte
+	offsets := make([]int, len(items))
+	for i, item := range items {
+		offsets[i] = len(payload)
+		payload = append(payload, item...)
+	}
+	return vpackAppendIndexed(buf, 0x06, payload, offsets)
+}
+
+// vpackAppendObject appends an object with the given attributes. values
+// have to be encoded already.
+func vpackAppendObject(buf []byte, keys []string, values [][]byte) []byte {
+	if len(keys) == 0 {
+		return append(buf, 0x0a)
+	}
+	var payload []byte
+	offsets := make([]int, len(keys))
+	for i := range keys {
+		offsets[i] = len(payload)
+		payload = vpackAppendString(payload, keys[i])
+		payload = append(payload, values[i]...)
+	}
+	// the index table of an object is sorted by attribute name
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return keys[order[a]] < keys[order[b]]
+	})
+	sorted := make([]int, len(keys))
+	for i, k := range order {
+		sorted[i] = offsets[k]
+	}
+	return vpackAppendIndexed(buf, 0x0b, payload, sorted)
+}
+
+// vpackTranslations maps the integer attribute names used by arangod for
+// system attributes to their names.
+var vpackTranslations = map[uint64]string{
+	1: "_key",
+	2: "_rev",
+	3: "_id",
+	4: "_from",
+	5: "_to",
+}
+
+func vpackReadLE(data []byte, n int) uint64 {
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(data[i])
+	}
+	return v
+}
+
+// vpackReadVarint reads a variable length integer as used by compact arrays
+// and objects. If reverse is set, the integer is read backwards from the end
+// of data. It returns the value and the number of bytes used.
+func vpackReadVarint(data []byte, reverse bool) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		b := data[i]
+		if reverse {
+			b = data[len(data)-1-i]
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// vpackWidth returns the width of the length fields of an array or object.
+func vpackWidth(head byte) int {
+	switch {
+	case head >= 0x0f:
+		return 1 << (head - 0x0f)
+	case head >= 0x0b:
+		return 1 << (head - 0x0b)
+	case head >= 0x06:
+		return 1 << (head - 0x06)
+	}
+	return 1 << (head - 0x02)
+}
+
+// vpackByteSize returns the number of bytes of the value at the start of
+// data.
+func vpackByteSize(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("unexpected end of velocypack data")
+	}
+	head := data[0]
+	need := func(n int) (int, error) {
+		if n < 0 || len(data) < n {
+			return 0, fmt.Errorf("unexpected end of velocypack data")
+		}
+		return n, nil
+	}
+	// header returns the byte length n of a value that starts with a header
+	// of the given size, which the value has to cover
+	header := func(n uint64, header int) (int, error) {
+		if n < uint64(header) || n > uint64(len(data)) {
+			return 0, fmt.Errorf("invalid velocypack length %d of type 0x%02x", n, head)
+		}
+		return int(n), nil
+	}
+	switch {
+	case head == 0x00, head == 0x01, head == 0x0a, head >= 0x18 && head <= 0x1a,
+		head == 0x1e, head == 0x1f, head >= 0x30 && head <= 0x3f:
+		return 1, nil
+	case head >= 0x02 && head <= 0x09, head >= 0x0b && head <= 0x12:
+		width := vpackWidth(head)
+		if _, err := need(1 + width); err != nil {
+			return 0, err
+		}
+		return header(vpackReadLE(data[1:], width), 1+width)
+	case head == 0x13 || head == 0x14:
+		size, n := vpackReadVarint(data[1:], false)
+		if n == 0 {
+			return 0, fmt.Errorf("invalid velocypack length")
+		}
+		// the header and the number of items take at least a byte each
+		return header(size, 2+n)
+	case head >= 0x1b && head <= 0x1d:
+		return need(9)
+	case head >= 0x20 && head <= 0x27:
+		return need(int(head-0x1f) + 1)
+	case head >= 0x28 && head <= 0x2f:
+		return need(int(head-0x27) + 1)
+	case head >= 0x40 && head <= 0xbe:
+		return need(int(head-0x40) + 1)
+	case head == 0xbf:
+		if _, err := need(9); err != nil {
+			return 0, err
+		}
+		return header(9+vpackReadLE(data[1:], 8), 9)
+	case head >= 0xc0 && head <= 0xc7:
+		n := int(head-0xbf) + 1
+		if _, err := need(n); err != nil {
+			return 0, err
+		}
+		return header(uint64(n)+vpackReadLE(data[1:], n-1), n)
+	}
+	return 0, fmt.Errorf("unsupported velocypack type 0x%02x", head)
+}
+
+// vpackItems returns the members of an array or the keys and values of an
+// object in the order they are stored.
+func vpackItems(data []byte) ([][]byte, error) {
+	size, err := vpackByteSize(data)
+	if err != nil {
+		return nil, err
+	}
+	head := data[0]
+	start, end, count := 0, size, 0
+
+	switch {
+	case head == 0x01 || head == 0x0a:
+		return nil, nil
+	case head == 0x13 || head == 0x14:
+		_, n := vpackReadVarint(data[1:], false)
+		nr, m := vpackReadVarint(data[:size], true)
+		if m == 0 || nr > uint64(size) {
+			return nil, fmt.Errorf("invalid velocypack item count of type 0x%02x", head)
+		}
+		start, end, count = 1+n, size-m, int(nr)
+		if head == 0x14 {
+			count *= 2
+		}
+	case head >= 0x02 && head <= 0x05:
+		start, count = 1+vpackWidth(head), -1
+	default:
+		// the byte length and the number of items, which is stored at the
+		// end for a width of 8
+		width := vpackWidth(head)
+		if size < 1+2*width {
+			return nil, fmt.Errorf("velocypack type 0x%02x of %d bytes is too short", head, size)
+		}
+		var nr uint64
+		if width == 8 {
+			nr = vpackReadLE(data[size-8:], 8)
+			start, end = 1+width, size-8
+		} else {
+			nr = vpackReadLE(data[1+width:], width)
+			start, end = 1+2*width, size
+		}
+		// the index table holds an offset for every item
+		if nr > uint64((end-start)/width) {
+			return nil, fmt.Errorf("velocypack type 0x%02x of %d bytes cannot hold %d items", head, size, nr)
+		}
+		count = int(nr)
+		end -= count * width
+		if head >= 0x0b {
+			count *= 2
+		}
+	}
+	if end < start {
+		return nil, fmt.Errorf("invalid velocypack header of type 0x%02x", head)
+	}
+
+	// zero bytes may be used as padding before the first item
+	for start < end && data[start] == 0x00 {
+		start++
+	}
+
+	var items [][]byte
+	for pos := start; pos < end && (count < 0 || len(items) < count); {
+		n, err := vpackByteSize(data[pos:end])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, data[pos:pos+n])
+		pos += n
+	}
+	return items, nil
+}
+
+// vpackDecode decodes the value at the start of data into nil, bool,
+// int64, uint64, float64, string, []byte, []interface{} or
+// map[string]interface{}.
+func vpackDecode(data []byte) (interface{}, error) {
+	if _, err := vpackByteSize(data); err != nil {
+		return nil, err
+	}
+	head := data[0]
+	switch {
+	case head == 0x18, head == 0x00:
+		return nil, nil
+	case head == 0x19:
+		return false, nil
+	case head == 0x1a:
+		return true, nil
+	case head == 0x1b:
+		return math.Float64frombits(vpackReadLE(data[1:], 8)), nil
+	case head == 0x1c:
+		return int64(vpackReadLE(data[1:], 8)), nil
+	case head >= 0x20 && head <= 0x27:
+		n := int(head - 0x1f)
+		v := vpackReadLE(data[1:], n)
+		// sign extension
+		shift := uint(64 - 8*n)
+		return int64(v<<shift) >> shift, nil
+	case head >= 0x28 && head <= 0x2f:
+		return vpackReadLE(data[1:], int(head-0x27)), nil
+	case head >= 0x30 && head <= 0x39:
+		return int64(head - 0x30), nil
+	case head >= 0x3a && head <= 0x3f:
+		return int64(head) - 0x40, nil
+	case head >= 0x40 && head <= 0xbe:
+		return string(data[1 : 1+int(head-0x40)]), nil
+	case head == 0xbf:
+		return string(data[9 : 9+int(vpackReadLE(data[1:], 8))]), nil
+	case head >= 0xc0 && head <= 0xc7:
+		n := int(head - 0xbf)
+		return append([]byte(nil), data[1+n:1+n+int(vpackReadLE(data[1:], n))]...), nil
+	case head == 0x1e, head == 0x1f:
+		return nil, nil
+	case head >= 0x01 && head <= 0x09, head == 0x13:
+		items, err := vpackItems(data)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]interface{}, len(items))
+		for i, item := range items {
+			if result[i], err = vpackDecode(item); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case head >= 0x0a && head <= 0x12, head == 0x14:
+		items, err := vpackItems(data)
+		if err != nil {
+			return nil, err
+		}
+		result := make(map[string]interface{}, len(items)/2)
+		for i := 0; i+1 < len(items); i += 2 {
+			key, err := vpackDecodeKey(items[i])
+			if err != nil {
+				return nil, err
+			}
+			if result[key], err = vpackDecode(items[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unsupported velocypack type 0x%02x", head)
+}
+
+func vpackDecodeKey(data []byte) (string, error) {
+	key, err := vpackDecode(data)
+	if err != nil {
+		return "", err
+	}
+	switch key := key.(type) {
+	case string:
+		return key, nil
+	case int64:
+		if name, ok := vpackTranslations[uint64(key)]; ok {
+			return name, nil
+		}
+	case uint64:
+		if name, ok := vpackTranslations[key]; ok {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("invalid velocypack attribute name %v", key)
+}
+
+// vpackUnmarshal decodes data into v, which has to be a pointer. The same
+// rules as for encoding/json apply.
+func vpackUnmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("vpackUnmarshal needs a non-nil pointer, got %T", v)
+	}
+	generic, err := vpackDecode(data)
+	if err != nil {
+		return err
+	}
+	return vpackAssign(rv.Elem(), generic)
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// vpackAssign stores a value as returned by vpackDecode into target.
+func vpackAssign(target reflect.Value, value interface{}) error {
+	if target.CanAddr() && target.Addr().Type().Implements(jsonUnmarshalerType) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		return target.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(data)
+	}
+
+	if value == nil {
+		switch target.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			target.Set(reflect.Zero(target.Type()))
+		}
+		return nil
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("cannot decode velocypack %T into %s", value, target.Type())
+	}
+
+	switch target.Kind() {
+	case reflect.Ptr:
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return vpackAssign(target.Elem(), value)
+	case reflect.Interface:
+		if target.NumMethod() != 0 {
+			return mismatch()
+		}
+		target.Set(reflect.ValueOf(value))
+		return nil
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch()
+		}
+		target.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch n := value.(type) {
+		case int64:
+			target.SetInt(n)
+		case uint64:
+			target.SetInt(int64(n))
+		case float64:
+			target.SetInt(int64(n))
+		default:
+			return mismatch()
+		}
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch n := value.(type) {
+		case int64:
+			target.SetUint(uint64(n))
+		case uint64:
+			target.SetUint(n)
+		case float64:
+			target.SetUint(uint64(n))
+		default:
+			return mismatch()
+		}
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch n := value.(type) {
+		case int64:
+			target.SetFloat(float64(n))
+		case uint64:
+			target.SetFloat(float64(n))
+		case float64:
+			target.SetFloat(n)
+		default:
+			return mismatch()
+		}
+		return nil
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		target.SetString(s)
+		return nil
+	case reflect.Slice:
+		if b, ok := value.([]byte); ok && target.Type().Elem().Kind() == reflect.Uint8 {
+			target.SetBytes(b)
+			return nil
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		slice := reflect.MakeSlice(target.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := vpackAssign(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+		return nil
+	case reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i := 0; i < target.Len() && i < len(items); i++ {
+			if err := vpackAssign(target.Index(i), items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok || target.Type().Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		if target.IsNil() {
+			target.Set(reflect.MakeMap(target.Type()))
+		}
+		for key, item := range object {
+			elem := reflect.New(target.Type().Elem()).Elem()
+			if err := vpackAssign(elem, item); err != nil {
+				return err
+			}
+			target.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), elem)
+		}
+		return nil
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for _, f := range vpackFields(target.Type()) {
+			item, ok := object[f.name]
+			if !ok {
+				// encoding/json falls back to case insensitive matching
+				for key, v := range object {
+					if strings.EqualFold(key, f.name) {
+						item, ok = v, true
+						break
+					}
+				}
+			}
+			if !ok {
+				continue
+			}
+			if err := vpackAssign(target.FieldByIndex(f.index), item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return mismatch()
+}
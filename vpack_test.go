@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVPackIntegers(t *testing.T) {
+	cases := []struct {
+		value int64
+		head  byte
+	}{
+		{0, 0x30},
+		{9, 0x39},
+		{-1, 0x3f},
+		{-6, 0x3a},
+		{10, 0x20},
+		{-7, 0x20},
+		{127, 0x20},
+		{-128, 0x20},
+		{128, 0x21},
+		{-129, 0x21},
+		{1 << 23, 0x23},
+		{-1 << 31, 0x23},
+		{1 << 40, 0x25},
+		{math.MaxInt64, 0x27},
+		{math.MinInt64, 0x27},
+	}
+	for _, c := range cases {
+		data, err := vpackAppend(nil, c.value)
+		if err != nil {
+			t.Fatalf("encoding %d: %v", c.value, err)
+		}
+		if data[0] != c.head {
+			t.Errorf("%d encoded with head 0x%02x, expected 0x%02x", c.value, data[0], c.head)
+		}
+		if size, err := vpackByteSize(data); err != nil || size != len(data) {
+			t.Errorf("%d: byte size %d (%v), expected %d", c.value, size, err, len(data))
+		}
+		decoded, err := vpackDecode(data)
+		if err != nil {
+			t.Fatalf("decoding %d: %v", c.value, err)
+		}
+		if decoded != c.value {
+			t.Errorf("%d decoded as %v (%T)", c.value, decoded, decoded)
+		}
+	}
+}
+
+func TestVPackUnsignedIntegers(t *testing.T) {
+	cases := []struct {
+		value uint64
+		head  byte
+	}{
+		{7, 0x37},
+		{10, 0x28},
+		{255, 0x28},
+		{256, 0x29},
+		{1 << 32, 0x2c},
+		{math.MaxUint64, 0x2f},
+	}
+	for _, c := range cases {
+		data, err := vpackAppend(nil, c.value)
+		if err != nil {
+			t.Fatalf("encoding %d: %v", c.value, err)
+		}
+		if data[0] != c.head {
+			t.Errorf("%d encoded with head 0x%02x, expected 0x%02x", c.value, data[0], c.head)
+		}
+		var decoded uint64
+		if err := vpackUnmarshal(data, &decoded); err != nil {
+			t.Fatalf("decoding %d: %v", c.value, err)
+		}
+		if decoded != c.value {
+			t.Errorf("%d decoded as %d", c.value, decoded)
+		}
+	}
+}
+
+func TestVPackScalars(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	cases := []interface{}{nil, true, false, 1.5, -0.25, "", "short", long}
+	for _, value := range cases {
+		data, err := vpackAppend(nil, value)
+		if err != nil {
+			t.Fatalf("encoding %v: %v", value, err)
+		}
+		decoded, err := vpackDecode(data)
+		if err != nil {
+			t.Fatalf("decoding %v: %v", value, err)
+		}
+		if decoded != value {
+			t.Errorf("%v decoded as %v", value, decoded)
+		}
+	}
+
+	data, _ := vpackAppend(nil, long)
+	if data[0] != 0xbf {
+		t.Errorf("long string encoded with head 0x%02x", data[0])
+	}
+}
+
+func TestVPackBinary(t *testing.T) {
+	data := []byte{0xc0, 3, 'a', 'b', 'c'}
+	decoded, err := vpackDecode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.([]byte), []byte("abc")) {
+		t.Errorf("binary decoded as %v", decoded)
+	}
+}
+
+// roundTrip encodes v, checks the byte size of the result and decodes it
+// into a generic value.
+func roundTrip(t *testing.T, v interface{}) ([]byte, interface{}) {
+	t.Helper()
+	data, err := vpackAppend(nil, v)
+	if err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+	if size, err := vpackByteSize(data); err != nil || size != len(data) {
+		t.Fatalf("byte size %d (%v), expected %d", size, err, len(data))
+	}
+	decoded, err := vpackDecode(data)
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	return data, decoded
+}
+
+func TestVPackIndexWidths(t *testing.T) {
+	cases := []struct {
+		items      int
+		itemSize   int
+		arrayHead  byte
+		objectHead byte
+	}{
+		{3, 1, 0x06, 0x0b},
+		{100, 1, 0x06, 0x0c},
+		{300, 1, 0x07, 0x0c},
+		{20, 4000, 0x08, 0x0d},
+	}
+	for _, c := range cases {
+		array := make([]interface{}, c.items)
+		object := make(map[string]interface{}, c.items)
+		for i := range array {
+			value := interface{}(int64(i % 10))
+			if c.itemSize > 1 {
+				value = strings.Repeat(string(rune('a'+i%26)), c.itemSize)
+			}
+			array[i] = value
+			object[strings.Repeat("k", i%5)+string(rune('A'+i%26))+string(rune('a'+i/26))] = value
+		}
+
+		data, decoded := roundTrip(t, array)
+		if data[0] != c.arrayHead {
+			t.Errorf("array of %d items encoded with head 0x%02x, expected 0x%02x", c.items, data[0], c.arrayHead)
+		}
+		if !reflect.DeepEqual(decoded, array) {
+			t.Errorf("array of %d items does not round trip", c.items)
+		}
+
+		data, decoded = roundTrip(t, object)
+		if data[0] != c.objectHead {
+			t.Errorf("object of %d items encoded with head 0x%02x, expected 0x%02x", c.items, data[0], c.objectHead)
+		}
+		if !reflect.DeepEqual(decoded, object) {
+			t.Errorf("object of %d items does not round trip", c.items)
+		}
+	}
+}
+
+func TestVPackDecodeCompound(t *testing.T) {
+	// compact array with more than 127 items needs multi byte varints
+	long := []byte{0x13, 0xcd, 0x01}
+	var longItems []interface{}
+	for i := 0; i < 200; i++ {
+		long = append(long, 0x30+byte(i%10))
+		longItems = append(longItems, int64(i%10))
+	}
+	long = append(long, 0x01, 0xc8)
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected interface{}
+	}{
+		{"empty array", []byte{0x01}, []interface{}{}},
+		{"empty object", []byte{0x0a}, map[string]interface{}{}},
+		{"array without index", []byte{0x02, 0x05, 0x31, 0x32, 0x33}, []interface{}{int64(1), int64(2), int64(3)}},
+		{"indexed array", []byte{0x06, 0x07, 0x02, 0x31, 0x32, 0x03, 0x04}, []interface{}{int64(1), int64(2)}},
+		{"padded array", []byte{0x06, 0x0d, 0x02, 0, 0, 0, 0, 0, 0, 0x31, 0x32, 0x09, 0x0a}, []interface{}{int64(1), int64(2)}},
+		{"array with 8 byte index", []byte{
+			0x09, 35, 0, 0, 0, 0, 0, 0, 0,
+			0x31, 0x3f,
+			9, 0, 0, 0, 0, 0, 0, 0,
+			10, 0, 0, 0, 0, 0, 0, 0,
+			2, 0, 0, 0, 0, 0, 0, 0,
+		}, []interface{}{int64(1), int64(-1)}},
+		{"compact array", []byte{0x13, 0x06, 0x31, 0x32, 0x33, 0x03}, []interface{}{int64(1), int64(2), int64(3)}},
+		{"long compact array", long, longItems},
+		{"compact object", []byte{0x14, 0x09, 0x41, 'a', 0x31, 0x41, 'b', 0x32, 0x02}, map[string]interface{}{"a": int64(1), "b": int64(2)}},
+		{"unsorted object", []byte{0x0f, 0x0b, 0x02, 0x41, 'b', 0x31, 0x41, 'a', 0x32, 0x03, 0x06}, map[string]interface{}{"b": int64(1), "a": int64(2)}},
+		{"translated key", []byte{0x14, 0x08, 0x31, 0x43, 'a', 'b', 'c', 0x01}, map[string]interface{}{"_key": "abc"}},
+	}
+	for _, c := range cases {
+		decoded, err := vpackDecode(c.data)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, c.expected) {
+			t.Errorf("%s: decoded as %#v, expected %#v", c.name, decoded, c.expected)
+		}
+	}
+}
+
+func TestVPackTruncated(t *testing.T) {
+	data, err := vpackAppend(nil, map[string]interface{}{"a": "some string", "b": []interface{}{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(data); i++ {
+		if _, err := vpackDecode(data[:i]); err == nil {
+			t.Errorf("decoding %d of %d bytes did not fail", i, len(data))
+		}
+	}
+}
+
+func TestVPackMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		// length shorter than the item count at the end
+		{0x09, 0x03, 0, 0, 0, 0, 0, 0, 0},
+		// length shorter than the header
+		{0x07, 0x04, 0x00, 0x00},
+		{0x06, 0x02},
+		{0x02, 0x01},
+		{0x13, 0x00},
+		// more items than the index table can hold
+		{0x06, 0x04, 0x09, 0x18},
+		{0x09, 0x11, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+		// item count without the header of a compact array
+		{0x13, 0x03, 0x7f},
+		// string lengths that overflow
+		{0xbf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0xc7, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+	} {
+		if _, err := vpackDecode(data); err == nil {
+			t.Errorf("decoding % x did not fail", data)
+		}
+	}
+}
+
+type vpackEmbedded struct {
+	Embedded string `json:"embedded"`
+}
+
+type vpackStruct struct {
+	vpackEmbedded
+	Name     string  `json:"name"`
+	Count    int     `json:"count"`
+	Negative int8    `json:"negative"`
+	Ratio    float64 `json:"ratio"`
+	Omitted  string  `json:"omitted,omitempty"`
+	Ignored  string  `json:"-"`
+	Untagged bool
+	Pointer  *uint             `json:"pointer"`
+	Nil      *uint             `json:"nil"`
+	List     []string          `json:"list"`
+	Map      map[string]int    `json:"map"`
+	Raw      json.RawMessage   `json:"raw"`
+	Nested   []vpackEmbedded   `json:"nested"`
+	Any      interface{}       `json:"any"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+func TestVPackStructRoundTrip(t *testing.T) {
+	pointer := uint(1234)
+	value := vpackStruct{
+		vpackEmbedded: vpackEmbedded{"inner"},
+		Name:          "name",
+		Count:         100000,
+		Negative:      -3,
+		Ratio:         0.5,
+		Ignored:       "ignored",
+		Untagged:      true,
+		Pointer:       &pointer,
+		List:          []string{"a", "b"},
+		Map:           map[string]int{"x": 1, "y": -20},
+		Raw:           json.RawMessage(`{"client":1,"index":[2,3]}`),
+		Nested:        []vpackEmbedded{{"n1"}, {"n2"}},
+		Any:           "any",
+	}
+
+	data, decoded := roundTrip(t, value)
+	object := decoded.(map[string]interface{})
+	for _, key := range []string{"omitted", "Ignored", "labels"} {
+		if _, ok := object[key]; ok {
+			t.Errorf("attribute %s should not be encoded", key)
+		}
+	}
+	if object["embedded"] != "inner" || object["Untagged"] != true {
+		t.Errorf("unexpected attributes %v", object)
+	}
+
+	var result vpackStruct
+	if err := vpackUnmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	value.Ignored = ""
+	if !reflect.DeepEqual(result, value) {
+		t.Errorf("decoded %+v, expected %+v", result, value)
+	}
+}
+
+func TestVPackMatchesJSON(t *testing.T) {
+	// the same value has to decode alike from json and velocypack
+	value := ReplicatedLogStatus{}
+	jsonData := []byte(`{"term":5,"leader":"PRMR-1","commitIndex":17,"spearhead":{"term":5,"index":18},
+		"participants":{"PRMR-1":{"role":"leader","term":5,"leadershipEstablished":true},"PRMR-2":{"errorCode":1478}},
+		"configuration":{"config":{"writeConcern":2,"waitForSync":true},"generation":3,
+		"participants":{"PRMR-1":{"allowedInQuorum":true,"allowedAsLeader":true}}}}`)
+	if err := json.Unmarshal(jsonData, &value); err != nil {
+		t.Fatal(err)
+	}
+	var generic interface{}
+	json.Unmarshal(jsonData, &generic)
+	data, err := vpackAppend(nil, generic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result ReplicatedLogStatus
+	if err := vpackUnmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, value) {
+		t.Errorf("velocypack decoded %+v, json %+v", result, value)
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// VelocyStream 1.1, see
+// https://github.com/arangodb/velocystream/blob/master/VelocyStream.md
+
+const (
+	vstVersionHeader     = "VST/1.1\r\n\r\n"
+	vstChunkHeaderSize   = 24
+	vstMaxChunkSize      = 30 * 1024
+	vstMessageRequest    = 1
+	vstMessageResponse   = 2
+	vstMessageAuth       = 1000
+	vstContentVelocypack = "application/x-velocypack"
+)
+
+// vstConnectionsPerHost is the number of connections requests to a single
+// coordinator are multiplexed over.
+const vstConnectionsPerHost = 4
+
+var vstRequestTypes = map[string]int64{
+	"DELETE":  0,
+	"GET":     1,
+	"POST":    2,
+	"PUT":     3,
+	"HEAD":    4,
+	"PATCH":   5,
+	"OPTIONS": 6,
+}
+
+// vstTransport is an http.RoundTripper that sends requests via VelocyStream
+// instead of HTTP. The URL scheme decides whether TLS is used.
+type vstTransport struct {
+	tlsConfig          *tls.Config
+	connectionsPerHost int
+	// disableKeepAlives uses a new connection for every request.
+	disableKeepAlives bool
+	dialContext       func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mutex sync.Mutex
+	pools map[string]*vstPool
+}
+
+func newVSTTransport(tlsConfig *tls.Config, connectionsPerHost int) *vstTransport {
+	return &vstTransport{
+		tlsConfig:          tlsConfig,
+		connectionsPerHost: connectionsPerHost,
+		pools:              make(map[string]*vstPool),
+	}
+}
+
+type vstPool struct {
+	mutex sync.Mutex
+	conns []*vstConn
+	next  uint64
+}
+
+func (t *vstTransport) pool(u *url.URL) *vstPool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	key := u.Scheme + "://" + u.Host
+	p, ok := t.pools[key]
+	if !ok {
+		p = &vstPool{conns: make([]*vstConn, t.connectionsPerHost)}
+		t.pools[key] = p
+	}
+	return p
+}
+
+// connection returns a connection to the host of req, establishing a new
+// one if the selected slot is empty or broken.
+func (t *vstTransport) connection(req *http.Request) (*vstConn, error) {
+	p := t.pool(req.URL)
+	slot := int(atomic.AddUint64(&p.next, 1) % uint64(len(p.conns)))
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if c := p.conns[slot]; c != nil && !c.broken() {
+		return c, nil
+	}
+	c, err := t.dial(req)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[slot] = c
+	return c, nil
+}
+
+func (t *vstTransport) dial(req *http.Request) (*vstConn, error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	host := req.URL.Host
+	if req.URL.Port() == "" {
+		host = net.JoinHostPort(req.URL.Hostname(), "8529")
+	}
+
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart("tcp", host)
+	}
+	dial := t.dialContext
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	conn, err := dial(req.Context(), "tcp", host)
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone("tcp", host, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL.Scheme == "https" {
+		config := &tls.Config{}
+		if t.tlsConfig != nil {
+			config = t.tlsConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = req.URL.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if trace != nil && trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+		err := tlsConn.HandshakeContext(req.Context())
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	if _, err := io.WriteString(conn, vstVersionHeader); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &vstConn{conn: conn, pending: make(map[uint64]*vstPending)}
+	go c.readLoop()
+	return c, nil
+}
+
+// CloseIdleConnections closes all pooled connections, requests in flight
+// fail.
+func (t *vstTransport) CloseIdleConnections() {
+	t.mutex.Lock()
+	pools := t.pools
+	t.pools = make(map[string]*vstPool)
+	t.mutex.Unlock()
+	for _, p := range pools {
+		p.mutex.Lock()
+		for _, c := range p.conns {
+			if c != nil {
+				c.fail(errVSTConnectionClosed)
+			}
+		}
+		p.mutex.Unlock()
+	}
+}
+
+var errVSTConnectionClosed = errors.New("vst connection closed")
+
+type vstResult struct {
+	data []byte
+	err  error
+}
+
+type vstPending struct {
+	result chan vstResult
+	trace  *httptrace.ClientTrace
+	data   []byte
+	first  bool
+}
+
+// vstConn is a single VelocyStream connection. Requests are multiplexed by
+// their message id.
+type vstConn struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	authMutex  sync.Mutex
+	// authorization is the Authorization header the connection is
+	// authenticated with.
+	authorization string
+
+	mutex   sync.Mutex
+	pending map[uint64]*vstPending
+	nextID  uint64
+	err     error
+}
+
+func (c *vstConn) broken() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err != nil
+}
+
+func (c *vstConn) fail(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+	for id, p := range c.pending {
+		p.result <- vstResult{err: err}
+		delete(c.pending, id)
+	}
+}
+
+func (c *vstConn) readLoop() {
+	header := make([]byte, vstChunkHeaderSize)
+	for {
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			c.fail(fmt.Errorf("vst connection closed: %w", err))
+			return
+		}
+		length := binary.LittleEndian.Uint32(header[0:])
+		id := binary.LittleEndian.Uint64(header[8:])
+		messageLength := binary.LittleEndian.Uint64(header[16:])
+		if length < vstChunkHeaderSize {
+			c.fail(fmt.Errorf("invalid vst chunk length %d", length))
+			return
+		}
+		data := make([]byte, length-vstChunkHeaderSize)
+		if _, err := io.ReadFull(c.conn, data); err != nil {
+			c.fail(fmt.Errorf("vst connection closed: %w", err))
+			return
+		}
+
+		c.mutex.Lock()
+		p, ok := c.pending[id]
+		if ok {
+			if p.first && p.trace != nil && p.trace.GotFirstResponseByte != nil {
+				p.trace.GotFirstResponseByte()
+			}
+			p.first = false
+			p.data = append(p.data, data...)
+			if uint64(len(p.data)) >= messageLength {
+				p.result <- vstResult{data: p.data}
+				delete(c.pending, id)
+			}
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// send writes a message and waits for the response message.
+func (c *vstConn) send(ctx context.Context, trace *httptrace.ClientTrace, message []byte) ([]byte, error) {
+	p := &vstPending{
+		result: make(chan vstResult, 1),
+		trace:  trace,
+		first:  true,
+	}
+
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = p
+	c.mutex.Unlock()
+
+	err := c.write(id, message)
+	if p.trace != nil && p.trace.WroteRequest != nil {
+		p.trace.WroteRequest(httptrace.WroteRequestInfo{Err: err})
+	}
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+
+	select {
+	case result := <-p.result:
+		return result.data, result.err
+	case <-ctx.Done():
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *vstConn) write(id uint64, message []byte) error {
+	const payloadSize = vstMaxChunkSize - vstChunkHeaderSize
+	numberOfChunks := (len(message) + payloadSize - 1) / payloadSize
+	if numberOfChunks == 0 {
+		numberOfChunks = 1
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	header := make([]byte, vstChunkHeaderSize)
+	for i := 0; i < numberOfChunks; i++ {
+		chunk := message[i*payloadSize:]
+		if len(chunk) > payloadSize {
+			chunk = chunk[:payloadSize]
+		}
+		chunkX := uint32(i) << 1
+		if i == 0 {
+			chunkX = uint32(numberOfChunks)<<1 | 1
+		}
+		binary.LittleEndian.PutUint32(header[0:], uint32(vstChunkHeaderSize+len(chunk)))
+		binary.LittleEndian.PutUint32(header[4:], chunkX)
+		binary.LittleEndian.PutUint64(header[8:], id)
+		binary.LittleEndian.PutUint64(header[16:], uint64(len(message)))
+		if _, err := c.conn.Write(header); err != nil {
+			return err
+		}
+		if _, err := c.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authenticate sends an authentication message if the connection is not yet
+// authenticated with the Authorization header of req.
+func (c *vstConn) authenticate(req *http.Request) error {
+	authorization := req.Header.Get("Authorization")
+	c.authMutex.Lock()
+	defer c.authMutex.Unlock()
+	if authorization == "" || authorization == c.authorization {
+		return nil
+	}
+
+	var header []interface{}
+	if user, password, ok := req.BasicAuth(); ok {
+		header = []interface{}{int64(1), int64(vstMessageAuth), "plain", user, password}
+	} else if fields := strings.Fields(authorization); len(fields) == 2 && strings.EqualFold(fields[0], "bearer") {
+		header = []interface{}{int64(1), int64(vstMessageAuth), "jwt", fields[1]}
+	} else {
+		return fmt.Errorf("unsupported authorization for vst")
+	}
+
+	message, err := vpackAppend(nil, header)
+	if err != nil {
+		return err
+	}
+	response, err := c.send(req.Context(), nil, message)
+	if err != nil {
+		return err
+	}
+	code, _, _, err := vstParseResponse(response)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusOK {
+		c.authorization = authorization
+	}
+	// otherwise the request fails with 401 and the token is refreshed
+	return nil
+}
+
+func vstParseResponse(message []byte) (int, http.Header, []byte, error) {
+	size, err := vpackByteSize(message)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid vst response header: %w", err)
+	}
+	decoded, err := vpackDecode(message[:size])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid vst response header: %w", err)
+	}
+	fields, ok := decoded.([]interface{})
+	if !ok || len(fields) < 3 {
+		return 0, nil, nil, fmt.Errorf("invalid vst response header: %v", decoded)
+	}
+	if t, ok := vpackToInt(fields[1]); !ok || t != vstMessageResponse {
+		return 0, nil, nil, fmt.Errorf("unexpected vst message type %v", fields[1])
+	}
+	code, ok := vpackToInt(fields[2])
+	if !ok {
+		return 0, nil, nil, fmt.Errorf("invalid vst response code %v", fields[2])
+	}
+
+	header := make(http.Header)
+	if len(fields) > 3 {
+		if meta, ok := fields[3].(map[string]interface{}); ok {
+			for key, value := range meta {
+				header.Set(key, fmt.Sprint(value))
+			}
+		}
+	}
+	return int(code), header, message[size:], nil
+}
+
+func vpackToInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func (t *vstTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	requestType, ok := vstRequestTypes[req.Method]
+	if !ok {
+		return nil, fmt.Errorf("method %s is not supported by vst", req.Method)
+	}
+
+	database, path := "_system", req.URL.Path
+	if strings.HasPrefix(path, "/_db/") {
+		parts := strings.SplitN(strings.TrimPrefix(path, "/_db/"), "/", 2)
+		database, path = parts[0], "/"
+		if len(parts) > 1 {
+			path = "/" + parts[1]
+		}
+		if name, err := url.PathUnescape(database); err == nil {
+			database = name
+		}
+	}
+
+	parameters := make(map[string]interface{})
+	for key, values := range req.URL.Query() {
+		parameters[key] = values[0]
+	}
+	meta := make(map[string]interface{})
+	for key, values := range req.Header {
+		if key == "Authorization" || key == "Content-Length" {
+			continue
+		}
+		meta[strings.ToLower(key)] = strings.Join(values, ",")
+	}
+	if _, ok := meta["accept"]; !ok {
+		meta["accept"] = "application/json"
+	}
+
+	message, err := vpackAppend(nil, []interface{}{
+		int64(1), int64(vstMessageRequest), database, requestType, path, parameters, meta,
+	})
+	if err != nil {
+		return nil, err
+	}
+	message = append(message, body...)
+
+	var c *vstConn
+	if t.disableKeepAlives {
+		if c, err = t.dial(req); err != nil {
+			return nil, err
+		}
+		defer c.fail(errVSTConnectionClosed)
+	} else if c, err = t.connection(req); err != nil {
+		return nil, err
+	}
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: c.conn})
+	}
+	if err := c.authenticate(req); err != nil {
+		return nil, err
+	}
+	response, err := c.send(req.Context(), httptrace.ContextClientTrace(req.Context()), message)
+	if err != nil {
+		return nil, err
+	}
+
+	code, header, responseBody, err := vstParseResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	// arangod answers with velocypack unless told otherwise, convert the
+	// body if the caller expects json
+	if strings.Contains(header.Get("Content-Type"), vstContentVelocypack) &&
+		!strings.Contains(meta["accept"].(string), vstContentVelocypack) && len(responseBody) > 0 {
+		value, err := vpackDecode(responseBody)
+		if err != nil {
+			return nil, fmt.Errorf("invalid velocypack response: %w", err)
+		}
+		if responseBody, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+		header.Set("Content-Type", "application/json")
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "VST/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(responseBody)),
+		ContentLength: int64(len(responseBody)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// vstTestRequest is a request as received by vstTestServer.
+type vstTestRequest struct {
+	Database   string
+	Type       int64
+	Path       string
+	Parameters map[string]interface{}
+	Meta       map[string]interface{}
+	Body       []byte
+	// Authenticated is set if the connection sent a valid auth message.
+	Authenticated bool
+}
+
+type vstTestResponse struct {
+	Code int
+	Meta map[string]interface{}
+	Body []byte
+}
+
+// vstTestServer is a minimal VelocyStream server. Every message is handled
+// concurrently and responses are sent in small chunks, so that responses of
+// concurrent requests interleave.
+type vstTestServer struct {
+	listener net.Listener
+	handler  func(req vstTestRequest) vstTestResponse
+	// chunkSize is the payload size of the response chunks.
+	chunkSize int
+
+	connections  int64
+	authMessages int64
+	wg           sync.WaitGroup
+
+	mutex sync.Mutex
+	conns []net.Conn
+	// credentials is the expected auth message, e.g. "plain:user:pass" or
+	// "jwt:token".
+	credentials string
+}
+
+func (s *vstTestServer) setCredentials(credentials string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.credentials = credentials
+}
+
+func newVSTTestServer(t *testing.T, handler func(req vstTestRequest) vstTestResponse) *vstTestServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveVSTTestServer(t, l, handler)
+}
+
+// serveVSTTestServer serves VelocyStream on the listener l.
+func serveVSTTestServer(t *testing.T, l net.Listener, handler func(req vstTestRequest) vstTestResponse) *vstTestServer {
+	s := &vstTestServer{listener: l, handler: handler, chunkSize: 16}
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *vstTestServer) url(path string) string {
+	return "http://" + s.listener.Addr().String() + path
+}
+
+func (s *vstTestServer) close() {
+	s.listener.Close()
+	s.closeConnections()
+	s.wg.Wait()
+}
+
+func (s *vstTestServer) closeConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *vstTestServer) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&s.connections, 1)
+		s.mutex.Lock()
+		s.conns = append(s.conns, c)
+		s.mutex.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(c)
+		}()
+	}
+}
+
+func (s *vstTestServer) handle(c net.Conn) {
+	defer c.Close()
+	version := make([]byte, len(vstVersionHeader))
+	if _, err := io.ReadFull(c, version); err != nil || string(version) != vstVersionHeader {
+		return
+	}
+
+	var writeMutex sync.Mutex
+	var authenticated int32
+	messages := make(map[uint64][]byte)
+	header := make([]byte, vstChunkHeaderSize)
+	for {
+		if _, err := io.ReadFull(c, header); err != nil {
+			return
+		}
+		length := binary.LittleEndian.Uint32(header[0:])
+		chunkX := binary.LittleEndian.Uint32(header[4:])
+		id := binary.LittleEndian.Uint64(header[8:])
+		messageLength := binary.LittleEndian.Uint64(header[16:])
+		chunk := make([]byte, length-vstChunkHeaderSize)
+		if _, err := io.ReadFull(c, chunk); err != nil {
+			return
+		}
+		if _, ok := messages[id]; !ok && chunkX&1 == 0 {
+			panic(fmt.Sprintf("message %d does not start with its first chunk", id))
+		}
+		messages[id] = append(messages[id], chunk...)
+		if uint64(len(messages[id])) < messageLength {
+			continue
+		}
+		message := messages[id]
+		delete(messages, id)
+
+		size, err := vpackByteSize(message)
+		if err != nil {
+			panic(err)
+		}
+		decoded, err := vpackDecode(message[:size])
+		if err != nil {
+			panic(err)
+		}
+		fields := decoded.([]interface{})
+		respond := func(r vstTestResponse) {
+			if r.Meta == nil {
+				r.Meta = map[string]interface{}{}
+			}
+			data, err := vpackAppend(nil, []interface{}{int64(1), int64(vstMessageResponse), int64(r.Code), r.Meta})
+			if err != nil {
+				panic(err)
+			}
+			s.writeMessage(c, &writeMutex, id, append(data, r.Body...))
+		}
+
+		if fields[1] == int64(vstMessageAuth) {
+			atomic.AddInt64(&s.authMessages, 1)
+			var credentials []string
+			for _, f := range fields[2:] {
+				credentials = append(credentials, f.(string))
+			}
+			s.mutex.Lock()
+			valid := strings.Join(credentials, ":") == s.credentials
+			s.mutex.Unlock()
+			if valid {
+				atomic.StoreInt32(&authenticated, 1)
+				respond(vstTestResponse{Code: http.StatusOK})
+			} else {
+				respond(vstTestResponse{Code: http.StatusUnauthorized})
+			}
+			continue
+		}
+
+		req := vstTestRequest{
+			Database:      fields[2].(string),
+			Type:          fields[3].(int64),
+			Path:          fields[4].(string),
+			Parameters:    fields[5].(map[string]interface{}),
+			Meta:          fields[6].(map[string]interface{}),
+			Body:          message[size:],
+			Authenticated: atomic.LoadInt32(&authenticated) == 1,
+		}
+		go respond(s.handler(req))
+	}
+}
+
+// writeMessage sends message in chunks of chunkSize. Chunks of concurrent
+// messages may interleave.
+func (s *vstTestServer) writeMessage(c net.Conn, writeMutex *sync.Mutex, id uint64, message []byte) {
+	n := (len(message) + s.chunkSize - 1) / s.chunkSize
+	for i := 0; i < n; i++ {
+		chunk := message[i*s.chunkSize:]
+		if len(chunk) > s.chunkSize {
+			chunk = chunk[:s.chunkSize]
+		}
+		chunkX := uint32(i) << 1
+		if i == 0 {
+			chunkX = uint32(n)<<1 | 1
+		}
+		header := make([]byte, vstChunkHeaderSize)
+		binary.LittleEndian.PutUint32(header[0:], uint32(vstChunkHeaderSize+len(chunk)))
+		binary.LittleEndian.PutUint32(header[4:], chunkX)
+		binary.LittleEndian.PutUint64(header[8:], id)
+		binary.LittleEndian.PutUint64(header[16:], uint64(len(message)))
+		writeMutex.Lock()
+		c.Write(append(header, chunk...))
+		writeMutex.Unlock()
+	}
+}
+
+func vpackBody(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := vpackAppend(nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newVSTTestClient() *http.Client {
+	return newHTTPClient(nil, VST, connectionOptions{})
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestVSTRequest(t *testing.T) {
+	requests := make(chan vstTestRequest, 1)
+	s := newVSTTestServer(t, func(req vstTestRequest) vstTestResponse {
+		requests <- req
+		return vstTestResponse{
+			Code: http.StatusCreated,
+			Meta: map[string]interface{}{"content-type": vstContentVelocypack, "x-arango-queue-time-seconds": "0.5"},
+			Body: vpackBody(t, map[string]interface{}{"result": map[string]interface{}{"index": 12}}),
+		}
+	})
+
+	req, _ := http.NewRequest("POST", s.url("/_db/my%20db/_api/log/1/insert?waitForSync=true"), strings.NewReader(`{"a":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := newVSTTestClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, resp)
+
+	received := <-requests
+	if received.Database != "my db" || received.Path != "/_api/log/1/insert" || received.Type != vstRequestTypes["POST"] {
+		t.Errorf("unexpected request %+v", received)
+	}
+	if received.Parameters["waitForSync"] != "true" {
+		t.Errorf("unexpected parameters %v", received.Parameters)
+	}
+	if received.Meta["content-type"] != "application/json" || received.Meta["accept"] != "application/json" {
+		t.Errorf("unexpected meta %v", received.Meta)
+	}
+	if string(received.Body) != `{"a":1}` {
+		t.Errorf("unexpected body %q", received.Body)
+	}
+
+	// the velocypack response is converted since json was accepted
+	if resp.StatusCode != http.StatusCreated || body != `{"result":{"index":12}}` {
+		t.Errorf("unexpected response %d %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" || resp.Header.Get("X-Arango-Queue-Time-Seconds") != "0.5" {
+		t.Errorf("unexpected header %v", resp.Header)
+	}
+}
+
+func TestVSTVelocypackResponse(t *testing.T) {
+	body := vpackBody(t, map[string]interface{}{"error": false, "code": 200})
+	s := newVSTTestServer(t, func(req vstTestRequest) vstTestResponse {
+		return vstTestResponse{Code: http.StatusOK, Meta: map[string]interface{}{"content-type": vstContentVelocypack}, Body: body}
+	})
+
+	req, _ := http.NewRequest("GET", s.url("/_api/version"), nil)
+	req.Header.Set("Accept", vstContentVelocypack)
+	resp, err := newVSTTestClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received := readBody(t, resp); received != string(body) {
+		t.Errorf("velocypack body was modified: %x", received)
+	}
+}
+
+func TestVSTLargeMessages(t *testing.T) {
+	s := newVSTTestServer(t, func(req vstTestRequest) vstTestResponse {
+		return vstTestResponse{Code: http.StatusOK, Meta: map[string]interface{}{"content-type": "text/plain"}, Body: req.Body}
+	})
+
+	// the request needs several chunks of vstMaxChunkSize
+	large := bytes.Repeat([]byte("0123456789"), 10000)
+	resp, err := newVSTTestClient().Post(s.url("/_api/echo"), "text/plain", bytes.NewReader(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, resp); body != string(large) {
+		t.Errorf("received %d bytes, expected %d", len(body), len(large))
+	}
+}
+
+func TestVSTMultiplexing(t *testing.T) {
+	const requests = 50
+	s := newVSTTestServer(t, func(req vstTestRequest) vstTestResponse {
+		var n int
+		fmt.Sscan(req.Parameters["n"].(string), &n)
+		// later requests are answered first
+		time.Sleep(time.Duration(requests-n) * time.Millisecond)
+		return vstTestResponse{Code: http.StatusOK, Meta: map[string]interface{}{"content-type": "text/plain"}, Body: []byte(strings.Repeat(fmt.Sprint(n), 20))}
+	})
+
+	client := newHTTPClient(nil, VST, connectionOptions{maxConnsPerHost: 1})
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.Get(s.url(fmt.Sprintf("/_api/test?n=%d", i)))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if expected := strings.Repeat(fmt.Sprint(i), 20); string(body) != expected {
+				errs <- fmt.Errorf("request %d received %q", i, body)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt64(&s.connections); n != 1 {
+		t.Errorf("requests used %d connections, expected 1", n)
+	}
+}
+
+func TestVSTAuthentication(t *testing.T) {
+	s := newVSTTestServer(t, func(req vstTestRequest) vstTestResponse {
+		if !req.Authenticated {
+			return vstTestResponse{Code: http.StatusUnauthorized}
+		}
+		return vstTestResponse{Code: http.StatusOK}
+	})
+	client := newHTTPClient(nil, VST, connectionOptions{maxConnsPerHost: 1})
+	get := func(setAuth func(req *http.Request)) int {
+		req, _ := http.NewRequest("GET", s.url("/_api/version"), nil)
+		setAuth(req)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	basic := func(password string) func(req *http.Request) {
+		return func(req *http.Request) { req.SetBasicAuth("root", password) }
+	}
+
+	s.setCredentials("plain:root:secret")
+	if code := get(basic("wrong")); code != http.StatusUnauthorized {
+		t.Errorf("wrong password answered with %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := get(basic("secret")); code != http.StatusOK {
+			t.Errorf("request %d answered with %d", i, code)
+		}
+	}
+	// the connection authenticates once per set of credentials
+	if n := atomic.LoadInt64(&s.authMessages); n != 2 {
+		t.Errorf("sent %d auth messages, expected 2", n)
+	}
+
+	s.setCredentials("jwt:token")
+	bearer := func(req *http.Request) { req.Header.Set("Authorization", "bearer token") }
+	if code := get(bearer); code != http.StatusOK {
+		t.Errorf("jwt request answered with %d", code)
+	}
+	if n := atomic.LoadInt64(&s.authMessages); n != 3 {
+		t.Errorf("sent %d auth messages, expected 3", n)
+	}
+
+	req, _ := http.NewRequest("GET", s.url("/_api/version"), nil)
+	req.Header.Set("Authorization", "Negotiate abc")
+	if _, err := client.Do(req); err == nil {
+		t.Errorf("unsupported authorization did not fail")
+	}
+}
+
+func TestVSTConnectionLoss(t *testing.T) {
+	block := make(chan struct{})
+	s := newVSTTestServer(t, func(req vstTestRequest) vstTestResponse {
+		if req.Parameters["block"] == "true" {
+			<-block
+		}
+		return vstTestResponse{Code: http.StatusOK}
+	})
+	defer close(block)
+	client := newHTTPClient(nil, VST, connectionOptions{maxConnsPerHost: 1})
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(s.url("/_api/test?block=true"))
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	for atomic.LoadInt64(&s.connections) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	s.closeConnections()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("request on closed connection did not fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("request on closed connection did not return")
+	}
+
+	// the broken connection is replaced
+	resp, err := client.Get(s.url("/_api/test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := atomic.LoadInt64(&s.connections); n != 2 {
+		t.Errorf("used %d connections, expected 2", n)
+	}
+}
+
+func TestVSTCancel(t *testing.T) {
+	block := make(chan struct{})
+	s := newVSTTestServer(t, func(req vstTestRequest) vstTestResponse {
+		<-block
+		return vstTestResponse{Code: http.StatusOK}
+	})
+	defer close(block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", s.url("/_api/test"), nil)
+	if _, err := newVSTTestClient().Do(req); err == nil || ctx.Err() == nil {
+		t.Errorf("request was not cancelled: %v", err)
+	}
+}
+
+func TestVSTParseResponse(t *testing.T) {
+	header := func(fields ...interface{}) []byte {
+		return vpackBody(t, fields)
+	}
+	valid := append(header(int64(1), int64(vstMessageResponse), int64(404), map[string]interface{}{"content-type": "text/plain"}), "body"...)
+
+	code, h, body, err := vstParseResponse(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 404 || h.Get("Content-Type") != "text/plain" || string(body) != "body" {
+		t.Errorf("parsed %d %v %q", code, h, body)
+	}
+
+	// the meta data is optional
+	if code, _, _, err := vstParseResponse(header(int64(1), int64(vstMessageResponse), uint64(200))); err != nil || code != 200 {
+		t.Errorf("parsed %d, %v", code, err)
+	}
+
+	invalid := map[string][]byte{
+		"empty":         nil,
+		"truncated":     valid[:5],
+		"no array":      vpackBody(t, "string"),
+		"short header":  header(int64(1), int64(vstMessageResponse)),
+		"request":       header(int64(1), int64(vstMessageRequest), int64(200)),
+		"invalid code":  header(int64(1), int64(vstMessageResponse), "200"),
+		"unknown value": {0xee},
+	}
+	for name, message := range invalid {
+		if _, _, _, err := vstParseResponse(message); err == nil {
+			t.Errorf("%s: parsing did not fail", name)
+		}
+	}
+}